@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"os"
 	"os/exec"
+	"sort"
+	"text/template"
 	"time"
 
 	"github.com/choplin/go-job/report"
@@ -13,6 +16,7 @@ import (
 type attemptTimeoutError struct {
 	endAt    time.Time
 	duration time.Duration
+	usage    *report.ResourceUsage
 }
 
 func (err *attemptTimeoutError) Error() string {
@@ -23,12 +27,37 @@ type attemptExitError struct {
 	endAt    time.Time
 	duration time.Duration
 	err      *exec.ExitError
+	usage    *report.ResourceUsage
 }
 
 func (err *attemptExitError) Error() string {
 	return err.err.Error()
 }
 
+// attemptOOMError is an exit of the process killed for exceeding the memory limit.
+type attemptOOMError struct {
+	endAt    time.Time
+	duration time.Duration
+	err      *exec.ExitError
+	usage    *report.ResourceUsage
+}
+
+func (err *attemptOOMError) Error() string {
+	return "out of memory"
+}
+
+// attemptCriteriaError is an exit of the process which does not meet the success criteria.
+type attemptCriteriaError struct {
+	endAt     time.Time
+	duration  time.Duration
+	criterion string
+	usage     *report.ResourceUsage
+}
+
+func (err *attemptCriteriaError) Error() string {
+	return err.criterion
+}
+
 type Command struct {
 	id         string
 	name       string
@@ -37,41 +66,94 @@ type Command struct {
 	timeout    *time.Duration
 	maxAttempt int
 	reporters  report.ReporterList
+	dir        string
+	env        []string
+	lockConfig *LockConfig
+	lockFile   *os.File
+	limits     *ResourceLimits
+	credential *Credential
+	criteria   *SuccessCriteria
+	// templates are the command and arguments as templates if they are enabled.
+	templates     []*template.Template
+	scheduledTime time.Time
+	startAt       time.Time
 }
 
 func NewCommand(name string, timeout *time.Duration, maxAttempt int, reporterConfig *report.ReporterConfig, commandStr string, args ...string) (*Command, error) {
 	id := generateId()
 
-	reporters, err := report.NewReporterList(id, name, reporterConfig)
+	reporters, err := report.NewReporterList(id, name, maxAttempt, reporterConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize reporter: %s", err)
 	}
 
 	return &Command{
-		id,
-		name,
-		commandStr,
-		args,
-		timeout,
-		maxAttempt,
-		reporters}, nil
+		id:         id,
+		name:       name,
+		commandStr: commandStr,
+		args:       args,
+		timeout:    timeout,
+		maxAttempt: maxAttempt,
+		reporters:  reporters,
+	}, nil
+}
+
+func (c *Command) Id() string {
+	return c.id
+}
+
+func (c *Command) Name() string {
+	return c.name
+}
+
+// SetDir sets the working directory of the process. The current directory is used if dir is empty.
+func (c *Command) SetDir(dir string) {
+	c.dir = dir
+}
+
+// SetEnv sets environment variables of the process in addition to the current environment.
+func (c *Command) SetEnv(env map[string]string) {
+	c.env = make([]string, 0, len(env))
+	for k, v := range env {
+		c.env = append(c.env, k+"="+v)
+	}
+	sort.Strings(c.env)
 }
 
 func (c *Command) Start() chan bool {
 	done := make(chan bool)
 	go func() {
+		if c.lockConfig != nil {
+			if proceed, success := c.acquireLock(); !proceed {
+				done <- success
+				return
+			}
+		}
+
 		success := false
 		startAt := time.Now()
+		c.startAt = startAt
 		c.reporters.CommandStart(startAt)
 
 		for attemptCount := 1; attemptCount <= c.maxAttempt; attemptCount++ {
-			if err := c.attempt(attemptCount); err != nil {
+			// an instance which has killed this one takes over
+			if c.lockKilled() {
+				break
+			}
+			err := c.attempt(attemptCount)
+			// the process has exited
+			c.recordLockPid(0)
+			if err != nil {
 
 				switch e := err.(type) {
 				case *attemptExitError:
-					c.reporters.AttemptFail(attemptCount, e.err, e.endAt, e.duration)
+					c.reporters.AttemptFail(attemptCount, e.err, e.endAt, e.duration, e.usage)
 				case *attemptTimeoutError:
-					c.reporters.AttemptTimeout(attemptCount, e.endAt, e.duration)
+					c.reporters.AttemptTimeout(attemptCount, e.endAt, e.duration, e.usage)
+				case *attemptOOMError:
+					c.reporters.AttemptOOM(attemptCount, e.err, e.endAt, e.duration, e.usage)
+				case *attemptCriteriaError:
+					c.reporters.AttemptCriteriaFail(attemptCount, e.criterion, e.endAt, e.duration, e.usage)
 				default:
 					c.reporters.AttemptUnknownError(attemptCount, e, time.Now())
 				}
@@ -86,6 +168,7 @@ func (c *Command) Start() chan bool {
 		} else {
 			c.reporters.CommandFail(endAt, endAt.Sub(startAt))
 		}
+		c.releaseLock()
 		done <- success
 	}()
 	return done
@@ -93,7 +176,38 @@ func (c *Command) Start() chan bool {
 
 func (c *Command) attempt(count int) error {
 	startAt := time.Now()
-	cmd := exec.Command(c.commandStr, c.args...)
+	commandStr, args, err := c.expandTemplates(count)
+	if err != nil {
+		return fmt.Errorf("failed to expand templates. %s", err)
+	}
+	cmd := exec.Command(commandStr, args...)
+	cmd.Dir = c.dir
+	env := c.env
+	if c.credential != nil {
+		env = append(c.credential.env(), c.env...)
+		c.credential.apply(cmd)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var cg *cgroup
+	if c.limits != nil && c.limits.hasCgroup() {
+		if cg = c.createCgroup(cmd, count); cg != nil {
+			defer cg.remove()
+		}
+	}
+
+	if c.limits != nil && c.limits.hasRlimits() {
+		if err := wrapWithRlimits(cmd, c.limits); err != nil {
+			return fmt.Errorf("failed to set resource limits. %s", err)
+		}
+	}
+
+	var stdoutMatcher, stderrMatcher *outputMatcher
+	if c.criteria != nil {
+		stdoutMatcher = newOutputMatcher("stdout", c.criteria.StdoutMust, c.criteria.StdoutMustNot)
+		stderrMatcher = newOutputMatcher("stderr", c.criteria.StderrMust, c.criteria.StderrMustNot)
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -101,6 +215,8 @@ func (c *Command) attempt(count int) error {
 	}
 	waitStdout := make(chan bool)
 	go func() {
+		// waitStdout must be closed after the logger has finished
+		defer close(waitStdout)
 		c.reporters.StartStdoutLogger(count)
 		defer c.reporters.FinishStdoutLogger()
 
@@ -111,9 +227,9 @@ func (c *Command) attempt(count int) error {
 			n, err = stdout.Read(buf)
 			if n > 0 {
 				c.reporters.StdoutLog(string(buf[0:n]))
+				stdoutMatcher.write(buf[0:n])
 			}
 		}
-		close(waitStdout)
 	}()
 
 	stderr, err := cmd.StderrPipe()
@@ -122,6 +238,8 @@ func (c *Command) attempt(count int) error {
 	}
 	waitStderr := make(chan bool)
 	go func() {
+		// waitStderr must be closed after the logger has finished
+		defer close(waitStderr)
 		c.reporters.StartStderrLogger(count)
 		defer c.reporters.FinishStderrLogger()
 
@@ -132,20 +250,24 @@ func (c *Command) attempt(count int) error {
 			n, err = stderr.Read(buf)
 			if n > 0 {
 				c.reporters.StderrLog(string(buf[0:n]))
+				stderrMatcher.write(buf[0:n])
 			}
 		}
-		close(waitStderr)
 	}()
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start process(%s %). %s", cmd.Path, cmd.Args, err)
+		return fmt.Errorf("failed to start process(%s %v). %s", cmd.Path, cmd.Args, err)
 	}
 
 	pid := cmd.Process.Pid
 	c.reporters.AttemptStart(count, pid, startAt)
+	c.recordLockPid(pid)
 
 	done := make(chan error)
 	go func() {
+		// Wait closes the pipes, so all output must be read before calling it
+		<-waitStdout
+		<-waitStderr
 		done <- cmd.Wait()
 	}()
 
@@ -155,31 +277,53 @@ func (c *Command) attempt(count int) error {
 	} else {
 		timer = time.After(*c.timeout)
 	}
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+
 	select {
 	case <-timer:
 		if err := cmd.Process.Kill(); err != nil {
 			return fmt.Errorf("failed to kill process %d. %s", pid, err)
 		}
+		// descendants of the process may still hold the pipes
+		stdout.Close()
+		stderr.Close()
 		<-done
 		<-waitStdout
 		<-waitStderr
 		endAt := time.Now()
-		return &attemptTimeoutError{endAt, endAt.Sub(startAt)}
-	case err := <-done:
-		<-waitStdout
-		<-waitStderr
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				endAt := time.Now()
-				return &attemptExitError{endAt, endAt.Sub(startAt), exitErr}
-			} else {
-				fmt.Errorf("process exited with unknown error. %s", err)
-			}
-			return fmt.Errorf("exit with failure")
-		}
+		return &attemptTimeoutError{endAt, endAt.Sub(startAt), resourceUsage(cmd.ProcessState, cg)}
+	case <-c.watchLockKilled(stopWatching):
+		// another instance has killed the process, and its descendants may still hold the pipes
+		stdout.Close()
+		stderr.Close()
+		err = <-done
+	case err = <-done:
 	}
+	<-waitStdout
+	<-waitStderr
 	endAt := time.Now()
-	c.reporters.AttemptSucceed(count, endAt, endAt.Sub(startAt))
+	usage := resourceUsage(cmd.ProcessState, cg)
+	code := 0
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return fmt.Errorf("process exited with unknown error. %s", err)
+		}
+		if cg != nil && cg.oomKilled() {
+			return &attemptOOMError{endAt, endAt.Sub(startAt), exitErr, usage}
+		}
+		if c.criteria == nil || !c.criteria.acceptsExitCode(exitErr.ExitCode()) {
+			return &attemptExitError{endAt, endAt.Sub(startAt), exitErr, usage}
+		}
+		code = exitErr.ExitCode()
+	}
+	if c.criteria != nil {
+		if criterion := c.unmetCriterion(code, stdoutMatcher, stderrMatcher, env); criterion != "" {
+			return &attemptCriteriaError{endAt, endAt.Sub(startAt), criterion, usage}
+		}
+	}
+	c.reporters.AttemptSucceed(count, endAt, endAt.Sub(startAt), usage)
 	return nil
 }
 
@@ -0,0 +1,125 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Credential is the user and groups running the process.
+type Credential struct {
+	Uid uint32
+	Gid uint32
+	// Groups replaces supplementary groups of the process. The process has none if it is empty.
+	Groups []uint32
+	// ResetEnv sets HOME, USER and LOGNAME of the process to Home and Username. Variables set
+	// by SetEnv take precedence.
+	ResetEnv bool
+	Username string
+	Home     string
+}
+
+// LookupCredential finds a user and groups by names or numbers. The current user is kept if
+// name is empty. The primary group of the user is used if group is empty, and groups of the
+// user are used as supplementary groups if groups is nil. A uid without an entry in the user
+// database has no primary group, so group must be given for it.
+func LookupCredential(name string, group string, groups []string) (*Credential, error) {
+	c := &Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Groups: []uint32{}}
+	var u *user.User
+	if name != "" {
+		var err error
+		if u, err = lookupUser(name); err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid of user %s: %s", name, u.Uid)
+		}
+		c.Uid = uint32(uid)
+		c.Username = u.Username
+		c.Home = u.HomeDir
+		if u.Gid == "" && group == "" {
+			// never run with the primary group of the current process
+			return nil, fmt.Errorf("user %s has no primary group. specify the group", name)
+		}
+		if u.Gid != "" {
+			gid, err := strconv.ParseUint(u.Gid, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gid of user %s: %s", name, u.Gid)
+			}
+			c.Gid = uint32(gid)
+		}
+	}
+	if group != "" {
+		gid, err := lookupGroup(group)
+		if err != nil {
+			return nil, err
+		}
+		c.Gid = gid
+	}
+
+	if groups == nil && u != nil && u.Username != "" {
+		if ids, err := u.GroupIds(); err == nil {
+			groups = ids
+		}
+	}
+	for _, g := range groups {
+		gid, err := lookupGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		c.Groups = append(c.Groups, gid)
+	}
+	return c, nil
+}
+
+// lookupUser accepts a uid which does not have an entry in the user database.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err != nil {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if u, err := user.LookupId(name); err == nil {
+		return u, nil
+	}
+	return &user.User{Uid: name}, nil
+}
+
+func lookupGroup(name string) (uint32, error) {
+	if gid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid of group %s: %s", name, g.Gid)
+	}
+	return uint32(gid), nil
+}
+
+// SetCredential runs the process as another user. The current process must have the privilege.
+func (c *Command) SetCredential(credential *Credential) {
+	c.credential = credential
+}
+
+// env returns variables of the user if ResetEnv is set.
+func (c *Credential) env() []string {
+	if !c.ResetEnv {
+		return nil
+	}
+	var env []string
+	if c.Home != "" {
+		env = append(env, "HOME="+c.Home)
+	}
+	if c.Username != "" {
+		env = append(env, "USER="+c.Username, "LOGNAME="+c.Username)
+	}
+	return env
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLookupCredential(t *testing.T) {
+	current := &Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid()), Groups: []uint32{}}
+	tests := []struct {
+		name   string
+		user   string
+		group  string
+		groups []string
+		want   *Credential
+	}{
+		{"current user", "", "", nil, current},
+		{"current user with groups", "", "", []string{"root", "54321"},
+			&Credential{Uid: current.Uid, Gid: current.Gid, Groups: []uint32{0, 54321}}},
+		{"user name", "root", "", []string{}, &Credential{Uid: 0, Gid: 0, Groups: []uint32{}, Username: "root", Home: "/root"}},
+		{"uid of a user", "0", "54321", []string{"0"}, &Credential{Uid: 0, Gid: 54321, Groups: []uint32{0}, Username: "root", Home: "/root"}},
+		{"uid without an entry", "54321", "54322", nil, &Credential{Uid: 54321, Gid: 54322, Groups: []uint32{}}},
+	}
+	for _, tt := range tests {
+		c, err := LookupCredential(tt.user, tt.group, tt.groups)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, c, tt.want)
+		}
+	}
+}
+
+func TestLookupCredentialGroupsOfUser(t *testing.T) {
+	c, err := LookupCredential("root", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, g := range c.Groups {
+		found = found || g == 0
+	}
+	if !found {
+		t.Errorf("got groups %v, which do not have the group of root", c.Groups)
+	}
+}
+
+func TestLookupCredentialErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		group  string
+		groups []string
+	}{
+		{"unknown user", "no-such-user", "", nil},
+		{"uid without a group", "54321", "", nil},
+		{"unknown group", "", "no-such-group", nil},
+		{"unknown supplementary group", "", "", []string{"root", "no-such-group"}},
+	}
+	for _, tt := range tests {
+		if c, err := LookupCredential(tt.user, tt.group, tt.groups); err == nil {
+			t.Errorf("%s: got %+v without an error", tt.name, c)
+		}
+	}
+}
+
+func TestCredentialEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *Credential
+		want []string
+	}{
+		{"not reset", &Credential{Username: "root", Home: "/root"}, nil},
+		{"reset", &Credential{ResetEnv: true, Username: "root", Home: "/root"}, []string{"HOME=/root", "USER=root", "LOGNAME=root"}},
+		{"uid without an entry", &Credential{ResetEnv: true}, nil},
+	}
+	for _, tt := range tests {
+		if env := tt.c.env(); !reflect.DeepEqual(env, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, env, tt.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package command
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// apply makes cmd run as the user.
+func (c *Credential) apply(cmd *exec.Cmd) {
+	groups := c.Groups
+	if groups == nil {
+		groups = []uint32{}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: c.Uid, Gid: c.Gid, Groups: groups}}
+}
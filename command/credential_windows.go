@@ -0,0 +1,13 @@
+package command
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// apply makes cmd fail to start, since Windows cannot run a process as another user by uid.
+func (c *Credential) apply(cmd *exec.Cmd) {
+	if cmd.Err == nil {
+		cmd.Err = fmt.Errorf("running a command as another user is not supported on this platform")
+	}
+}
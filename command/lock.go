@@ -0,0 +1,215 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+// Policies applied when another instance of the command holds the lock.
+const (
+	LockSkip = "skip"
+	LockWait = "wait"
+	LockKill = "kill"
+)
+
+// lockKilledExt is the extension of a mark file created next to a lock file when the holder
+// is killed, so that it does not retry. The mark is separate from the pid in the lock file,
+// which the holder keeps rewriting.
+const lockKilledExt = ".killed"
+
+const lockPollInterval = 100 * time.Millisecond
+
+type LockConfig struct {
+	// Directory stores a lock file named after the command.
+	Directory string
+	Policy    string
+	// Timeout is how long to wait for the running instance with LockWait, and how long to
+	// wait before sending SIGKILL to it with LockKill. 0 means no limit.
+	Timeout time.Duration
+}
+
+// SetLock prevents instances of the command with the same name from running at the same time.
+func (c *Command) SetLock(config *LockConfig) {
+	c.lockConfig = config
+}
+
+func lockFilePath(dir string, name string) string {
+	return filepath.Join(dir, strings.Replace(name, "/", "_", -1)+".lock")
+}
+
+// acquireLock applies the policy if another instance holds the lock, and reports the
+// decision. It returns whether the command should run, and the result of the command if not.
+// A failure of the lock is reported as a failure of the command.
+func (c *Command) acquireLock() (bool, bool) {
+	config := c.lockConfig
+	if err := os.MkdirAll(config.Directory, 0755); err != nil {
+		return c.failLock("failed to create a lock directory. %s", err)
+	}
+	// lock files are never removed, since another instance may be waiting on the same file
+	f, err := os.OpenFile(lockFilePath(config.Directory, c.name), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return c.failLock("failed to open a lock file. %s", err)
+	}
+
+	locked, err := tryLock(f)
+	if err != nil {
+		f.Close()
+		return c.failLock("failed to lock %s. %s", f.Name(), err)
+	}
+	if locked {
+		c.takeLock(f)
+		return true, true
+	}
+
+	holder := readLockPid(f)
+	startAt := time.Now()
+	switch config.Policy {
+	case LockSkip:
+		f.Close()
+		c.reporters.CommandLock(report.LockSkipped, holder, startAt, 0)
+		return false, true
+	case LockKill:
+		// the pid is read after the mark is created, since the holder checks the mark after
+		// recording the pid of a new process
+		if err := ioutil.WriteFile(f.Name()+lockKilledExt, nil, 0644); err != nil {
+			f.Close()
+			return c.failLock("failed to mark %s as killed. %s", f.Name(), err)
+		}
+		if pid := readLockPid(f); pid > 0 {
+			holder = pid
+		}
+		signalProcess(holder, syscall.SIGTERM)
+	}
+
+	killed := false
+	for !locked {
+		time.Sleep(lockPollInterval)
+		if locked, err = tryLock(f); err != nil {
+			f.Close()
+			return c.failLock("failed to lock %s. %s", f.Name(), err)
+		}
+		if locked || config.Timeout == 0 || time.Since(startAt) < config.Timeout {
+			continue
+		}
+		if config.Policy == LockWait {
+			f.Close()
+			now := time.Now()
+			c.reporters.CommandLock(report.LockWaitTimeout, holder, now, now.Sub(startAt))
+			return false, false
+		}
+		if !killed {
+			// the holder may have started another process before noticing the mark
+			if pid := readLockPid(f); pid > 0 {
+				holder = pid
+			}
+			signalProcess(holder, syscall.SIGKILL)
+			killed = true
+		}
+	}
+
+	c.takeLock(f)
+	now := time.Now()
+	if config.Policy == LockKill {
+		c.reporters.CommandLock(report.LockKilled, holder, now, now.Sub(startAt))
+	} else {
+		c.reporters.CommandLock(report.LockWaited, holder, now, now.Sub(startAt))
+	}
+	return true, true
+}
+
+func (c *Command) failLock(format string, a ...interface{}) (bool, bool) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	now := time.Now()
+	c.reporters.CommandFail(now, 0)
+	return false, false
+}
+
+// takeLock holds the locked file. A mark left for the previous holder is removed.
+func (c *Command) takeLock(f *os.File) {
+	c.lockFile = f
+	c.writeLockFile("0")
+	if err := os.Remove(f.Name() + lockKilledExt); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "failed to remove %s. %s\n", f.Name()+lockKilledExt, err)
+	}
+}
+
+// lockKilled reports whether another instance has killed this one.
+func (c *Command) lockKilled() bool {
+	if c.lockFile == nil {
+		return false
+	}
+	_, err := os.Stat(c.lockFile.Name() + lockKilledExt)
+	return err == nil
+}
+
+// watchLockKilled returns a channel which is closed when another instance has killed this
+// one, until stop is closed.
+func (c *Command) watchLockKilled(stop <-chan struct{}) <-chan struct{} {
+	killed := make(chan struct{})
+	if c.lockFile == nil {
+		return killed
+	}
+	go func() {
+		ticker := time.NewTicker(lockPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if c.lockKilled() {
+					close(killed)
+					return
+				}
+			}
+		}
+	}()
+	return killed
+}
+
+func (c *Command) writeLockFile(content string) {
+	if c.lockFile != nil {
+		writeLockFile(c.lockFile, content)
+	}
+}
+
+// recordLockPid records the pid of the running process, which is killed by another
+// instance with LockKill. The process is killed at once if the mark has been created
+// before the pid is recorded.
+func (c *Command) recordLockPid(pid int) {
+	if c.lockFile == nil {
+		return
+	}
+	if c.lockKilled() {
+		signalProcess(pid, syscall.SIGTERM)
+		return
+	}
+	c.writeLockFile(strconv.Itoa(pid))
+}
+
+func writeLockFile(f *os.File, content string) {
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(content+"\n"), 0)
+	}
+}
+
+func readLockPid(f *os.File) int {
+	b, _ := ioutil.ReadFile(f.Name())
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+func (c *Command) releaseLock() {
+	if c.lockFile != nil {
+		c.lockFile.Close()
+		c.lockFile = nil
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockSupported reports whether locks of commands are supported on this platform.
+const LockSupported = true
+
+func tryLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func signalProcess(pid int, sig syscall.Signal) {
+	if pid <= 0 {
+		return
+	}
+	if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+		fmt.Fprintf(os.Stderr, "failed to send %s to process %d. %s\n", sig, pid, err)
+	}
+}
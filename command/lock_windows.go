@@ -0,0 +1,28 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockSupported reports whether locks of commands are supported on this platform.
+const LockSupported = false
+
+func tryLock(f *os.File) (bool, error) {
+	return false, fmt.Errorf("locks are not supported on this platform")
+}
+
+// signalProcess kills the process whatever the signal is, since Windows cannot send signals.
+func signalProcess(pid int, sig syscall.Signal) {
+	if pid <= 0 {
+		return
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return
+	}
+	if err := p.Kill(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to kill process %d. %s\n", pid, err)
+	}
+}
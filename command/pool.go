@@ -0,0 +1,183 @@
+package command
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Pool runs submitted commands with limited concurrency. Commands with higher priority start
+// first, and commands with the same priority start in order of submission. Commands are queued
+// until Start is called, so that priorities apply to all of them.
+type Pool struct {
+	concurrency int
+	onFinish    func(result *PoolResult, progress PoolProgress)
+
+	mu       sync.Mutex
+	started  bool
+	queue    poolQueue
+	running  int
+	seq      int
+	results  []*PoolResult
+	progress PoolProgress
+	// pending counts submitted commands whose finish handler has not returned. done is
+	// signaled when it becomes 0, and waited is set when Wait has returned.
+	pending int
+	done    *sync.Cond
+	waited  bool
+
+	// handlerMu serializes calls of the finish handler, which are made without p.mu held
+	handlerMu sync.Mutex
+}
+
+type PoolResult struct {
+	Command  *Command
+	Priority int
+	Success  bool
+	StartAt  time.Time
+	EndAt    time.Time
+}
+
+// PoolProgress counts commands in the pool by their state.
+type PoolProgress struct {
+	Queued    int
+	Running   int
+	Succeeded int
+	Failed    int
+}
+
+// Total is the number of commands submitted to the pool.
+func (p PoolProgress) Total() int {
+	return p.Queued + p.Running + p.Succeeded + p.Failed
+}
+
+// NewPool returns a pool which runs up to concurrency commands at the same time. 0 means no limit.
+func NewPool(concurrency int) *Pool {
+	p := &Pool{concurrency: concurrency}
+	p.done = sync.NewCond(&p.mu)
+	return p
+}
+
+// SetFinishHandler sets a function called each time a command finishes. Calls are serialized,
+// and the handler may call Progress and Submit.
+func (p *Pool) SetFinishHandler(f func(result *PoolResult, progress PoolProgress)) {
+	p.onFinish = f
+}
+
+// Submit queues the command, which starts at once if the pool has been started and the
+// concurrency allows. The pool closes the command when it finishes. Commands can be submitted
+// while Wait is waiting for others, e.g. by the finish handler, but not after Wait has
+// returned, in which case the command is not closed.
+func (p *Pool) Submit(c *Command, priority int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.waited {
+		return fmt.Errorf("the pool has finished")
+	}
+	p.pending++
+	result := &PoolResult{Command: c, Priority: priority}
+	p.results = append(p.results, result)
+	heap.Push(&p.queue, &poolItem{result, p.seq})
+	p.seq++
+	p.progress.Queued++
+	p.dispatch()
+	return nil
+}
+
+// Start starts running the queued commands.
+func (p *Pool) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = true
+	p.dispatch()
+}
+
+// dispatch starts queued commands while the concurrency allows. p.mu must be held.
+func (p *Pool) dispatch() {
+	for p.started && p.queue.Len() > 0 && (p.concurrency == 0 || p.running < p.concurrency) {
+		item := heap.Pop(&p.queue).(*poolItem)
+		p.running++
+		p.progress.Queued--
+		p.progress.Running++
+		go p.run(item.result)
+	}
+}
+
+func (p *Pool) run(result *PoolResult) {
+	result.StartAt = time.Now()
+	result.Success = <-result.Command.Start()
+	result.Command.Close()
+	result.EndAt = time.Now()
+
+	p.mu.Lock()
+	p.running--
+	p.progress.Running--
+	if result.Success {
+		p.progress.Succeeded++
+	} else {
+		p.progress.Failed++
+	}
+	p.dispatch()
+	progress := p.progress
+	p.mu.Unlock()
+
+	if p.onFinish != nil {
+		p.handlerMu.Lock()
+		p.onFinish(result, progress)
+		p.handlerMu.Unlock()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending--; p.pending == 0 {
+		p.done.Broadcast()
+	}
+}
+
+func (p *Pool) Progress() PoolProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progress
+}
+
+// Wait waits for all the submitted commands to finish after Start is called, and returns their results in order
+// of submission.
+func (p *Pool) Wait() []*PoolResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.pending > 0 {
+		p.done.Wait()
+	}
+	p.waited = true
+	return append([]*PoolResult{}, p.results...)
+}
+
+type poolItem struct {
+	result *PoolResult
+	seq    int
+}
+
+// poolQueue is a heap of queued commands ordered by priority and submission.
+type poolQueue []*poolItem
+
+func (q poolQueue) Len() int { return len(q) }
+
+func (q poolQueue) Less(i, j int) bool {
+	if q[i].result.Priority != q[j].result.Priority {
+		return q[i].result.Priority > q[j].result.Priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q poolQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *poolQueue) Push(x interface{}) { *q = append(*q, x.(*poolItem)) }
+
+func (q *poolQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
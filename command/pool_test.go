@@ -0,0 +1,115 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+func newTestCommand(t *testing.T, name string, commandStr string, args ...string) *Command {
+	var timeout time.Duration
+	c, err := NewCommand(name, &timeout, 1, &report.ReporterConfig{}, commandStr, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// waitPool fails the test if the pool does not finish in time, e.g. by a deadlock.
+func waitPool(t *testing.T, p *Pool) []*PoolResult {
+	results := make(chan []*PoolResult, 1)
+	go func() { results <- p.Wait() }()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(10 * time.Second):
+		t.Fatal("the pool has not finished")
+		return nil
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	p := NewPool(1)
+	var finished []string
+	p.SetFinishHandler(func(r *PoolResult, progress PoolProgress) {
+		finished = append(finished, r.Command.Name())
+	})
+	tests := []struct {
+		name     string
+		priority int
+	}{
+		{"a", 0}, {"b", 2}, {"c", 1}, {"d", 2}, {"e", -1},
+	}
+	for _, tt := range tests {
+		if err := p.Submit(newTestCommand(t, tt.name, "true"), tt.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Start()
+	results := waitPool(t, p)
+
+	if got := strings.Join(finished, ""); got != "bdcae" {
+		t.Errorf("finished in order %s, want bdcae", got)
+	}
+	for i, r := range results {
+		if r.Command.Name() != tests[i].name || !r.Success {
+			t.Errorf("result %d is %s, success %v", i, r.Command.Name(), r.Success)
+		}
+	}
+}
+
+func TestPoolProgress(t *testing.T) {
+	p := NewPool(2)
+	var last PoolProgress
+	p.SetFinishHandler(func(r *PoolResult, progress PoolProgress) {
+		if progress.Running > 2 {
+			t.Errorf("%d commands are running with concurrency 2", progress.Running)
+		}
+		last = progress
+	})
+	for i := 0; i < 5; i++ {
+		commandStr := "true"
+		if i%2 == 1 {
+			commandStr = "false"
+		}
+		p.Submit(newTestCommand(t, commandStr, commandStr), 0)
+	}
+	p.Start()
+	waitPool(t, p)
+
+	want := PoolProgress{Succeeded: 3, Failed: 2}
+	if last != want || p.Progress() != want {
+		t.Errorf("got progress %+v and %+v, want %+v", last, p.Progress(), want)
+	}
+}
+
+func TestPoolHandlerCallsPool(t *testing.T) {
+	p := NewPool(1)
+	submitted := false
+	p.SetFinishHandler(func(r *PoolResult, progress PoolProgress) {
+		if p.Progress().Total() == 0 {
+			t.Errorf("no command in the pool")
+		}
+		if !submitted {
+			submitted = true
+			if err := p.Submit(newTestCommand(t, "second", "true"), 0); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	p.Submit(newTestCommand(t, "first", "true"), 0)
+	p.Start()
+	results := waitPool(t, p)
+
+	if len(results) != 2 || results[1].Command.Name() != "second" || !results[1].Success {
+		t.Errorf("got %d results, want the command submitted by the handler", len(results))
+	}
+
+	c := newTestCommand(t, "late", "true")
+	defer c.Close()
+	if err := p.Submit(c, 0); err == nil {
+		t.Errorf("Submit after Wait has succeeded")
+	}
+}
@@ -0,0 +1,41 @@
+package command
+
+import (
+	"time"
+)
+
+const defaultCgroupDirectory = "/sys/fs/cgroup/go_job"
+
+// ResourceLimits limits resources of each attempt. A zero value leaves the resource unlimited.
+type ResourceLimits struct {
+	// AddressSpace, OpenFiles, CPUTime and CoreSize are set as rlimits of the process before
+	// it executes the command, and are inherited by its descendants.
+	AddressSpace uint64
+	OpenFiles    uint64
+	CPUTime      time.Duration
+	// CoreSize is nil to leave the limit as is, since 0 disables core files.
+	CoreSize *uint64
+
+	// MemoryMax, CPUMax and PidsMax are set to a transient cgroup v2 created for each attempt
+	// under CgroupDirectory. The attempt runs without the cgroup if cgroup v2 is not available.
+	MemoryMax uint64
+	// CPUMax is the number of CPUs, such as 0.5.
+	CPUMax  float64
+	PidsMax int
+	// CgroupDirectory is /sys/fs/cgroup/go_job if empty.
+	CgroupDirectory string
+}
+
+func (l *ResourceLimits) hasRlimits() bool {
+	return l.AddressSpace > 0 || l.OpenFiles > 0 || l.CPUTime > 0 || l.CoreSize != nil
+}
+
+func (l *ResourceLimits) hasCgroup() bool {
+	return l.MemoryMax > 0 || l.CPUMax > 0 || l.PidsMax > 0
+}
+
+// SetResourceLimits sets limits of resources used by each attempt. A process killed for
+// exceeding MemoryMax is reported as out of memory.
+func (c *Command) SetResourceLimits(limits *ResourceLimits) {
+	c.limits = limits
+}
@@ -0,0 +1,366 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+const cgroup2SuperMagic = 0x63677270
+
+const cgroupCPUPeriod = 100000
+
+// usageControllers are enabled if they are available, only to report usage.
+var usageControllers = []string{"io"}
+
+// maxRSSUnit is the unit of ru_maxrss, which is kilobytes on Linux.
+const maxRSSUnit = 1024
+
+const (
+	// rlimitsEnv and credentialEnv pass rlimits and the credential to the wrapper.
+	rlimitsEnv    = "GO_JOB_RLIMITS"
+	credentialEnv = "GO_JOB_CREDENTIAL"
+	// wrapperExitCode is the exit code of the wrapper which has failed to execute the command.
+	wrapperExitCode = 126
+)
+
+// RunRlimitWrapper runs the wrapper instead of the program when it has been re-executed by
+// wrapWithRlimits, and never returns in that case. Programs which run commands with rlimits
+// must call it first in main.
+func RunRlimitWrapper() {
+	if rlimits, ok := os.LookupEnv(rlimitsEnv); ok {
+		execWrapped(rlimits)
+	}
+}
+
+// cgroup is a transient cgroup v2 of an attempt.
+type cgroup struct {
+	dir string
+	fd  *os.File
+}
+
+// createCgroup makes the process start in a new cgroup. It returns nil if the cgroup cannot
+// be created, and the attempt runs without it.
+func (c *Command) createCgroup(cmd *exec.Cmd, count int) *cgroup {
+	name := fmt.Sprintf("%s-%s-%d", strings.Replace(c.name, "/", "_", -1), c.id, count)
+	cg, err := newCgroup(c.limits, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create a cgroup. the attempt runs without it. %s\n", err)
+		return nil
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.fd.Fd())
+	return cg
+}
+
+func newCgroup(limits *ResourceLimits, name string) (*cgroup, error) {
+	parent := limits.CgroupDirectory
+	if parent == "" {
+		parent = defaultCgroupDirectory
+	}
+	var controllers []string
+	files := make(map[string]string)
+	if limits.MemoryMax > 0 {
+		controllers = append(controllers, "memory")
+		files["memory.max"] = strconv.FormatUint(limits.MemoryMax, 10)
+		// the whole cgroup is killed at once like a process without a cgroup
+		files["memory.oom.group"] = "1"
+	}
+	if limits.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+		files["cpu.max"] = fmt.Sprintf("%d %d", int(limits.CPUMax*cgroupCPUPeriod), cgroupCPUPeriod)
+	}
+	if limits.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+		files["pids.max"] = strconv.Itoa(limits.PidsMax)
+	}
+
+	// controllers are available in a cgroup only if they are enabled in its parent
+	if err := enableControllers(filepath.Dir(parent), controllers); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+	for _, c := range usageControllers {
+		if enableControllers(filepath.Dir(parent), []string{c}) == nil {
+			enableControllers(parent, []string{c})
+		}
+	}
+
+	cg := &cgroup{dir: filepath.Join(parent, name)}
+	if err := os.Mkdir(cg.dir, 0755); err != nil {
+		return nil, err
+	}
+	for file, value := range files {
+		if err := ioutil.WriteFile(filepath.Join(cg.dir, file), []byte(value), 0644); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	f, err := os.Open(cg.dir)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	cg.fd = f
+	return cg, nil
+}
+
+func enableControllers(dir string, controllers []string) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return err
+	}
+	if st.Type != cgroup2SuperMagic {
+		return fmt.Errorf("%s is not in cgroup v2", dir)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return err
+	}
+	enabled := make(map[string]bool)
+	for _, c := range strings.Fields(string(b)) {
+		enabled[c] = true
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, c := range strings.Fields(string(b)) {
+		available[c] = true
+	}
+	for _, c := range controllers {
+		if enabled[c] {
+			continue
+		}
+		if !available[c] {
+			return fmt.Errorf("%s controller is not available in %s", c, dir)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+"+c), 0644); err != nil {
+			return fmt.Errorf("failed to enable %s controller in %s. %s", c, dir, err)
+		}
+	}
+	return nil
+}
+
+// oomKilled reports whether a process in the cgroup has been killed for exceeding memory.max.
+func (cg *cgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(cg.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n > 0
+		}
+	}
+	return false
+}
+
+// usage reads statistics of the cgroup. memory.peak is only available since Linux 5.19.
+func (cg *cgroup) usage() *report.CgroupUsage {
+	u := &report.CgroupUsage{}
+	if v, ok := readCgroupStat(filepath.Join(cg.dir, "cpu.stat"), "usage_usec"); ok {
+		u.CPUTime = float64(v) / 1e6
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(cg.dir, "memory.peak")); err == nil {
+		u.MemoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	// io.stat has a line of key=value pairs for each device. it does not exist if the io
+	// controller is not available
+	if b, err := ioutil.ReadFile(filepath.Join(cg.dir, "io.stat")); err == nil {
+		for _, line := range strings.Split(string(b), "\n") {
+			for _, kv := range strings.Fields(line) {
+				n, _ := strconv.ParseInt(kv[strings.Index(kv, "=")+1:], 10, 64)
+				switch {
+				case strings.HasPrefix(kv, "rbytes="):
+					u.IOReadBytes += n
+				case strings.HasPrefix(kv, "wbytes="):
+					u.IOWriteBytes += n
+				}
+			}
+		}
+	}
+	return u
+}
+
+// readCgroupStat reads a value of a flat keyed file such as cpu.stat.
+func readCgroupStat(path string, key string) (int64, bool) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			n, err := strconv.ParseInt(fields[1], 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// remove kills processes left in the cgroup, and removes it.
+func (cg *cgroup) remove() {
+	if cg.fd != nil {
+		cg.fd.Close()
+	}
+	ioutil.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0644)
+	var err error
+	// the cgroup cannot be removed until the killed processes have exited
+	for i := 0; i < 100; i++ {
+		if err = syscall.Rmdir(cg.dir); err != syscall.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to remove a cgroup. %s\n", err)
+	}
+}
+
+// wrapWithRlimits makes the process start as a wrapper which sets rlimits and the credential
+// of the command to itself and then executes the command, so they are applied before the
+// command runs. The wrapper is the executable of this process re-executed.
+func wrapWithRlimits(cmd *exec.Cmd, limits *ResourceLimits) error {
+	if cmd.Err != nil {
+		// Start reports the error of finding the command
+		return nil
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, rlimitsEnv+"="+encodeRlimits(limits))
+	// the credential is set by the wrapper after rlimits, which may raise hard limits
+	if attr := cmd.SysProcAttr; attr != nil && attr.Credential != nil {
+		env = append(env, credentialEnv+"="+encodeCredential(attr.Credential))
+		attr.Credential = nil
+	}
+	cmd.Env = env
+	cmd.Args = append([]string{cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	return nil
+}
+
+func encodeRlimits(limits *ResourceLimits) string {
+	var rlimits []string
+	add := func(resource int, value uint64) {
+		rlimits = append(rlimits, fmt.Sprintf("%d=%d", resource, value))
+	}
+	if limits.AddressSpace > 0 {
+		add(syscall.RLIMIT_AS, limits.AddressSpace)
+	}
+	if limits.OpenFiles > 0 {
+		add(syscall.RLIMIT_NOFILE, limits.OpenFiles)
+	}
+	if limits.CPUTime > 0 {
+		add(syscall.RLIMIT_CPU, uint64((limits.CPUTime+time.Second-1)/time.Second))
+	}
+	if limits.CoreSize != nil {
+		add(syscall.RLIMIT_CORE, *limits.CoreSize)
+	}
+	return strings.Join(rlimits, ",")
+}
+
+func encodeCredential(c *syscall.Credential) string {
+	groups := make([]string, 0, len(c.Groups))
+	for _, g := range c.Groups {
+		groups = append(groups, strconv.FormatUint(uint64(g), 10))
+	}
+	return fmt.Sprintf("%d,%d,%s", c.Uid, c.Gid, strings.Join(groups, ":"))
+}
+
+// execWrapped runs in the wrapper. os.Args are the path of the command followed by its
+// arguments including the name.
+func execWrapped(rlimits string) {
+	credential, hasCredential := os.LookupEnv(credentialEnv)
+	os.Unsetenv(rlimitsEnv)
+	os.Unsetenv(credentialEnv)
+	if err := setRlimits(rlimits); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to set resource limits. %s\n", err)
+		os.Exit(wrapperExitCode)
+	}
+	if hasCredential {
+		if err := setCredential(credential); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set the credential. %s\n", err)
+			os.Exit(wrapperExitCode)
+		}
+	}
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, "failed to execute the command. no command is given\n")
+		os.Exit(wrapperExitCode)
+	}
+	err := syscall.Exec(os.Args[0], os.Args[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "failed to execute the command. %s\n", err)
+	os.Exit(wrapperExitCode)
+}
+
+func setRlimits(s string) error {
+	for _, kv := range strings.Split(s, ",") {
+		var resource int
+		var value uint64
+		if _, err := fmt.Sscanf(kv, "%d=%d", &resource, &value); err != nil {
+			return fmt.Errorf("invalid rlimit: %s", kv)
+		}
+		// syscall.Setrlimit keeps the limit of open files across exec, unlike the raw syscall
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setCredential(s string) error {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid credential: %s", s)
+	}
+	uid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid credential: %s", s)
+	}
+	gid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid credential: %s", s)
+	}
+	groups := []int{}
+	if fields[2] != "" {
+		for _, g := range strings.Split(fields[2], ":") {
+			n, err := strconv.Atoi(g)
+			if err != nil {
+				return fmt.Errorf("invalid credential: %s", s)
+			}
+			groups = append(groups, n)
+		}
+	}
+	// the order matters since setting the uid drops the privilege to set groups
+	if err := syscall.Setgroups(groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(uid)
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary run as the wrapper re-executed by wrapWithRlimits.
+func TestMain(m *testing.M) {
+	RunRlimitWrapper()
+	os.Exit(m.Run())
+}
+
+func TestEncodeRlimits(t *testing.T) {
+	zero := uint64(0)
+	tests := []struct {
+		limits *ResourceLimits
+		want   string
+	}{
+		{&ResourceLimits{OpenFiles: 64}, "7=64"},
+		{&ResourceLimits{AddressSpace: 1 << 30, CoreSize: &zero}, "9=1073741824,4=0"},
+		// CPU time is rounded up to seconds
+		{&ResourceLimits{CPUTime: 1500 * time.Millisecond}, "0=2"},
+		{&ResourceLimits{CPUTime: 2 * time.Second}, "0=2"},
+	}
+	for _, tt := range tests {
+		if got := encodeRlimits(tt.limits); got != tt.want {
+			t.Errorf("encodeRlimits(%+v) = %q, want %q", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestSetCredentialInvalid(t *testing.T) {
+	for _, s := range []string{"", "1,2", "a,2,", "1,b,", "1,2,3:c"} {
+		if err := setCredential(s); err == nil {
+			t.Errorf("setCredential(%q) succeeded", s)
+		}
+	}
+	c := &syscall.Credential{Uid: 1000, Gid: 100, Groups: []uint32{10, 20}}
+	if got := encodeCredential(c); got != "1000,100,10:20" {
+		t.Errorf("encodeCredential(%+v) = %q", c, got)
+	}
+}
+
+func TestWrapWithRlimits(t *testing.T) {
+	zero := uint64(0)
+	tests := []struct {
+		limits *ResourceLimits
+		script string
+		want   string
+	}{
+		{&ResourceLimits{OpenFiles: 64}, "ulimit -n", "64"},
+		{&ResourceLimits{CoreSize: &zero}, "ulimit -c", "0"},
+		{&ResourceLimits{CPUTime: 10 * time.Second}, "ulimit -t", "10"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("/bin/sh", "-c", tt.script)
+		if err := wrapWithRlimits(cmd, tt.limits); err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.script, err)
+		}
+		if got := strings.TrimSpace(string(out)); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
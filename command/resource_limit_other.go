@@ -0,0 +1,40 @@
+//go:build !linux
+
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/choplin/go-job/report"
+)
+
+// maxRSSUnit is the unit of ru_maxrss, which is bytes on macOS.
+const maxRSSUnit = 1
+
+type cgroup struct{}
+
+func (c *Command) createCgroup(cmd *exec.Cmd, count int) *cgroup {
+	fmt.Fprintf(os.Stderr, "failed to create a cgroup. the attempt runs without it. cgroup is not supported on this platform\n")
+	return nil
+}
+
+func (cg *cgroup) oomKilled() bool {
+	return false
+}
+
+func (cg *cgroup) usage() *report.CgroupUsage {
+	return nil
+}
+
+func (cg *cgroup) remove() {
+}
+
+// RunRlimitWrapper does nothing, since rlimits are not supported on this platform.
+func RunRlimitWrapper() {
+}
+
+func wrapWithRlimits(cmd *exec.Cmd, limits *ResourceLimits) error {
+	return fmt.Errorf("rlimits are not supported on this platform")
+}
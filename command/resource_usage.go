@@ -0,0 +1,34 @@
+//go:build !windows
+
+package command
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/choplin/go-job/report"
+)
+
+// resourceUsage returns nil if the process has not exited.
+func resourceUsage(state *os.ProcessState, cg *cgroup) *report.ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+	u := &report.ResourceUsage{
+		UserTime:                   state.UserTime().Seconds(),
+		SystemTime:                 state.SystemTime().Seconds(),
+		MaxRSS:                     int64(ru.Maxrss) * maxRSSUnit,
+		VoluntaryContextSwitches:   int64(ru.Nvcsw),
+		InvoluntaryContextSwitches: int64(ru.Nivcsw),
+		BlockInputs:                int64(ru.Inblock),
+		BlockOutputs:               int64(ru.Oublock),
+	}
+	if cg != nil {
+		u.Cgroup = cg.usage()
+	}
+	return u
+}
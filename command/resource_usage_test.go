@@ -0,0 +1,33 @@
+//go:build !windows
+
+package command
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestResourceUsage(t *testing.T) {
+	if u := resourceUsage(nil, nil); u != nil {
+		t.Errorf("got %v for a process which has not exited", u)
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", "i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	u := resourceUsage(cmd.ProcessState, nil)
+	if u == nil {
+		t.Fatal("no usage of an exited process")
+	}
+	if u.UserTime+u.SystemTime <= 0 {
+		t.Errorf("got no cpu time: %v", u)
+	}
+	// the shell uses at least a few hundred kilobytes, which tells the unit of ru_maxrss
+	if u.MaxRSS < 100*1024 {
+		t.Errorf("got max rss %d, which is too small in bytes", u.MaxRSS)
+	}
+	if u.Cgroup != nil {
+		t.Errorf("got cgroup usage without a cgroup: %v", u.Cgroup)
+	}
+}
@@ -0,0 +1,18 @@
+package command
+
+import (
+	"os"
+
+	"github.com/choplin/go-job/report"
+)
+
+// resourceUsage reports only times, since Windows has no rusage.
+func resourceUsage(state *os.ProcessState, cg *cgroup) *report.ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	return &report.ResourceUsage{
+		UserTime:   state.UserTime().Seconds(),
+		SystemTime: state.SystemTime().Seconds(),
+	}
+}
@@ -0,0 +1,191 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxMatchedLine is the length of a line matched with patterns. A longer line is split.
+const maxMatchedLine = 64 * 1024
+
+// postCheckWaitDelay is how long to wait for the output of a post-check after it has been killed.
+const postCheckWaitDelay = time.Second
+
+// maxPostCheckOutput is the length of output of a post-check included in the criterion.
+const maxPostCheckOutput = 1024
+
+// SuccessCriteria defines success of an attempt in addition to its exit code. An attempt
+// succeeds only if it meets all of them.
+type SuccessCriteria struct {
+	// ExitCodes are accepted exit codes. Only 0 is accepted if it is empty.
+	ExitCodes []int
+	// Patterns are matched with each line of the output. Each of Must patterns must match
+	// any line, and MustNot patterns must not match any line.
+	StdoutMust    []*regexp.Regexp
+	StdoutMustNot []*regexp.Regexp
+	StderrMust    []*regexp.Regexp
+	StderrMustNot []*regexp.Regexp
+	// PostCheck is a command run after the other criteria are met. The attempt fails if it
+	// does not exit with 0.
+	PostCheck        []string
+	PostCheckTimeout time.Duration
+}
+
+// SetSuccessCriteria sets criteria of a successful attempt. The criterion which is not met is
+// reported.
+func (c *Command) SetSuccessCriteria(criteria *SuccessCriteria) {
+	c.criteria = criteria
+}
+
+func (s *SuccessCriteria) acceptsExitCode(code int) bool {
+	if len(s.ExitCodes) == 0 {
+		return code == 0
+	}
+	for _, e := range s.ExitCodes {
+		if e == code {
+			return true
+		}
+	}
+	return false
+}
+
+// outputMatcher matches patterns with each line of an output stream. A nil matcher matches
+// nothing.
+type outputMatcher struct {
+	stream  string
+	must    []*regexp.Regexp
+	mustNot []*regexp.Regexp
+	matched []bool
+	// violation is the first line matching a pattern of mustNot.
+	violation string
+	violated  *regexp.Regexp
+	line      []byte
+}
+
+// newOutputMatcher returns nil if there is no pattern.
+func newOutputMatcher(stream string, must []*regexp.Regexp, mustNot []*regexp.Regexp) *outputMatcher {
+	if len(must) == 0 && len(mustNot) == 0 {
+		return nil
+	}
+	return &outputMatcher{stream: stream, must: must, mustNot: mustNot, matched: make([]bool, len(must))}
+}
+
+func (m *outputMatcher) write(b []byte) {
+	if m == nil {
+		return
+	}
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			m.line = append(m.line, b...)
+			if len(m.line) >= maxMatchedLine {
+				m.flush()
+			}
+			return
+		}
+		m.line = append(m.line, b[:i]...)
+		m.flush()
+		b = b[i+1:]
+	}
+}
+
+// flush matches the buffered line.
+func (m *outputMatcher) flush() {
+	if m == nil || len(m.line) == 0 {
+		return
+	}
+	line := string(m.line)
+	m.line = m.line[:0]
+	for i, p := range m.must {
+		if !m.matched[i] && p.MatchString(line) {
+			m.matched[i] = true
+		}
+	}
+	if m.violated != nil {
+		return
+	}
+	for _, p := range m.mustNot {
+		if p.MatchString(line) {
+			m.violated = p
+			m.violation = line
+			return
+		}
+	}
+}
+
+// unmet returns the criterion which is not met, or an empty string.
+func (m *outputMatcher) unmet() string {
+	if m == nil {
+		return ""
+	}
+	m.flush()
+	if m.violated != nil {
+		return fmt.Sprintf("%s matches %q: %s", m.stream, m.violated, m.violation)
+	}
+	for i, p := range m.must {
+		if !m.matched[i] {
+			return fmt.Sprintf("%s does not match %q", m.stream, p)
+		}
+	}
+	return ""
+}
+
+// unmetCriterion returns the criterion which the attempt exited with the code does not
+// meet, or an empty string. The post-check runs only if the others are met.
+func (c *Command) unmetCriterion(code int, stdout *outputMatcher, stderr *outputMatcher, env []string) string {
+	if !c.criteria.acceptsExitCode(code) {
+		return fmt.Sprintf("exit code %d is not accepted", code)
+	}
+	for _, m := range []*outputMatcher{stdout, stderr} {
+		if s := m.unmet(); s != "" {
+			return s
+		}
+	}
+	if len(c.criteria.PostCheck) > 0 {
+		if err := c.runPostCheck(env); err != nil {
+			return fmt.Sprintf("post-check has failed. %s", err)
+		}
+	}
+	return ""
+}
+
+func (c *Command) runPostCheck(env []string) error {
+	ctx := context.Background()
+	if c.criteria.PostCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.criteria.PostCheckTimeout)
+		defer cancel()
+	}
+	check := c.criteria.PostCheck
+	cmd := exec.CommandContext(ctx, check[0], check[1:]...)
+	// children left by the killed post-check may keep the output open
+	cmd.WaitDelay = postCheckWaitDelay
+	cmd.Dir = c.dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if c.credential != nil {
+		c.credential.apply(cmd)
+	}
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout")
+	}
+	s := strings.TrimSpace(string(out))
+	if len(s) > maxPostCheckOutput {
+		s = "..." + s[len(s)-maxPostCheckOutput:]
+	}
+	if s == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", err, s)
+}
@@ -0,0 +1,66 @@
+package command
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsExitCode(t *testing.T) {
+	tests := []struct {
+		codes []int
+		code  int
+		want  bool
+	}{
+		{nil, 0, true},
+		{nil, 1, false},
+		{[]int{0, 3}, 3, true},
+		{[]int{0, 3}, 0, true},
+		{[]int{3}, 0, false},
+	}
+	for _, tt := range tests {
+		s := &SuccessCriteria{ExitCodes: tt.codes}
+		if got := s.acceptsExitCode(tt.code); got != tt.want {
+			t.Errorf("%v accepts %d: got %v, want %v", tt.codes, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestOutputMatcher(t *testing.T) {
+	re := func(patterns ...string) []*regexp.Regexp {
+		var ret []*regexp.Regexp
+		for _, p := range patterns {
+			ret = append(ret, regexp.MustCompile(p))
+		}
+		return ret
+	}
+	long := strings.Repeat("x", maxMatchedLine)
+	tests := []struct {
+		name    string
+		must    []string
+		mustNot []string
+		writes  []string
+		want    string
+	}{
+		{"no pattern", nil, nil, []string{"ERROR\n"}, ""},
+		{"must", []string{"^done$"}, nil, []string{"start\n", "done\n"}, ""},
+		{"must not matched", []string{"^done$", "^ok"}, nil, []string{"done\n"}, `stdout does not match "^ok"`},
+		{"line split across writes", []string{"^done$"}, nil, []string{"do", "ne\nnext\n"}, ""},
+		{"last line without newline", []string{"^done$"}, nil, []string{"start\ndone"}, ""},
+		{"must not", nil, []string{"ERROR"}, []string{"ok\n", "ERROR 1\n", "ERROR 2\n"}, `stdout matches "ERROR": ERROR 1`},
+		{"must not before must", []string{"^done$"}, []string{"ERROR"}, []string{"ERROR\n"}, `stdout matches "ERROR": ERROR`},
+		{"long line is split", []string{"^y"}, nil, []string{long, "y\n"}, ""},
+	}
+	for _, tt := range tests {
+		m := newOutputMatcher("stdout", re(tt.must...), re(tt.mustNot...))
+		if (m == nil) != (len(tt.must) == 0 && len(tt.mustNot) == 0) {
+			t.Errorf("%s: got matcher %v", tt.name, m)
+		}
+		for _, w := range tt.writes {
+			m.write([]byte(w))
+		}
+		if got := m.unmet(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
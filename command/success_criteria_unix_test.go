@@ -0,0 +1,46 @@
+//go:build !windows
+
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnmetCriterion(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     int
+		criteria *SuccessCriteria
+		want     string
+	}{
+		{"exit code", 1, &SuccessCriteria{}, "exit code 1 is not accepted"},
+		{"accepted exit code", 2, &SuccessCriteria{ExitCodes: []int{2}}, ""},
+		{"post-check", 0, &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "test \"$FOO\" = bar"}}, ""},
+		{"post-check not run", 1, &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "exit 1"}}, "exit code 1 is not accepted"},
+		{"post-check fails", 0, &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "echo not found; exit 3"}},
+			"post-check has failed. exit status 3: not found"},
+		{"post-check fails silently", 0, &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "exit 3"}},
+			"post-check has failed. exit status 3"},
+		{"post-check times out", 0, &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "sleep 10"}, PostCheckTimeout: 100 * time.Millisecond},
+			"post-check has failed. timeout"},
+	}
+	for _, tt := range tests {
+		c := &Command{criteria: tt.criteria}
+		if got := c.unmetCriterion(tt.code, nil, nil, []string{"FOO=bar"}); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPostCheckOutputIsTruncated(t *testing.T) {
+	c := &Command{criteria: &SuccessCriteria{PostCheck: []string{"/bin/sh", "-c", "printf 'a%.0s' $(seq 2000); echo end; exit 1"}}}
+	got := c.unmetCriterion(0, nil, nil, nil)
+	if !strings.HasPrefix(got, "post-check has failed. exit status 1: ...a") || !strings.HasSuffix(got, "end") {
+		t.Errorf("got %q", got)
+	}
+	if n := len(got) - len("post-check has failed. exit status 1: ..."); n != maxPostCheckOutput {
+		t.Errorf("got %d bytes of the output, want %d", n, maxPostCheckOutput)
+	}
+}
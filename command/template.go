@@ -0,0 +1,86 @@
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+var templateFuncs = template.FuncMap{
+	// date formats a time with a layout of the time package, e.g. {{.ScheduledTime | date "2006-01-02"}}
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+}
+
+// TemplateData is data of templates in the command and arguments.
+type TemplateData struct {
+	// Id is the id of the run.
+	Id      string
+	Name    string
+	Attempt int
+	// ScheduledTime is the time when the command is scheduled, or the start time of the
+	// command if it is not scheduled.
+	ScheduledTime time.Time
+}
+
+func parseTemplate(s string) (*template.Template, error) {
+	t, err := template.New("").Funcs(templateFuncs).Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q. %s", s, err)
+	}
+	return t, nil
+}
+
+// ValidateTemplate returns an error if s is not a valid template of SetTemplate.
+func ValidateTemplate(s string) error {
+	_, err := parseTemplate(s)
+	return err
+}
+
+// SetTemplate makes the command and arguments templates of text/template, which are expanded
+// with TemplateData for each attempt, e.g. --attempt={{.Attempt}}.
+func (c *Command) SetTemplate() error {
+	var templates []*template.Template
+	for _, s := range append([]string{c.commandStr}, c.args...) {
+		t, err := parseTemplate(s)
+		if err != nil {
+			return err
+		}
+		templates = append(templates, t)
+	}
+	c.templates = templates
+	return nil
+}
+
+// SetScheduledTime sets ScheduledTime of templates.
+func (c *Command) SetScheduledTime(t time.Time) {
+	c.scheduledTime = t
+}
+
+// expandTemplates returns the command and arguments of the attempt.
+func (c *Command) expandTemplates(count int) (string, []string, error) {
+	if c.templates == nil {
+		return c.commandStr, c.args, nil
+	}
+	data := &TemplateData{
+		Id:            c.id,
+		Name:          c.name,
+		Attempt:       count,
+		ScheduledTime: c.scheduledTime,
+	}
+	if data.ScheduledTime.IsZero() {
+		data.ScheduledTime = c.startAt
+	}
+
+	values := make([]string, 0, len(c.templates))
+	for _, t := range c.templates {
+		var b bytes.Buffer
+		if err := t.Execute(&b, data); err != nil {
+			return "", nil, err
+		}
+		values = append(values, b.String())
+	}
+	return values[0], values[1:], nil
+}
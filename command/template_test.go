@@ -0,0 +1,62 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpandTemplates(t *testing.T) {
+	scheduled := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	started := time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC)
+	tests := []struct {
+		name      string
+		template  bool
+		args      []string
+		scheduled time.Time
+		command   string
+		want      []string
+	}{
+		{"disabled", false, []string{"--attempt={{.Attempt}}"}, scheduled, "/bin/{{.Name}}", []string{"--attempt={{.Attempt}}"}},
+		{"fields", true, []string{"--id={{.Id}}", "--attempt={{.Attempt}}"}, scheduled, "/bin/backup", []string{"--id=id", "--attempt=2"}},
+		{"scheduled time", true, []string{`{{.ScheduledTime | date "2006-01-02T15"}}`}, scheduled, "/bin/backup", []string{"2026-01-02T03"}},
+		{"start time", true, []string{`{{.ScheduledTime | date "2006-01-02T15"}}`}, time.Time{}, "/bin/backup", []string{"2026-02-03T04"}},
+	}
+	for _, tt := range tests {
+		c := &Command{id: "id", name: "backup", commandStr: "/bin/{{.Name}}", args: tt.args, scheduledTime: tt.scheduled, startAt: started}
+		if tt.template {
+			if err := c.SetTemplate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		command, args, err := c.expandTemplates(2)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if command != tt.command || !reflect.DeepEqual(args, tt.want) {
+			t.Errorf("%s: got %s %v, want %s %v", tt.name, command, args, tt.command, tt.want)
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	if err := ValidateTemplate("{{.Attempt"); err == nil {
+		t.Errorf("no error for an unclosed action")
+	}
+	if err := ValidateTemplate("{{.Attempt | nodate}}"); err == nil {
+		t.Errorf("no error for an undefined function")
+	}
+
+	c := &Command{commandStr: "/bin/echo", args: []string{"{{.Attempt"}}
+	if err := c.SetTemplate(); err == nil {
+		t.Errorf("no error for an invalid argument")
+	}
+	c = &Command{commandStr: "/bin/echo", args: []string{"{{.Unknown}}"}}
+	if err := c.SetTemplate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.expandTemplates(1); err == nil {
+		t.Errorf("no error for an unknown field")
+	}
+}
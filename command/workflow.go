@@ -0,0 +1,215 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+// Outcomes of a step in a workflow.
+const (
+	StepSucceeded = "succeeded"
+	StepFailed    = "failed"
+	// StepSkipped is a step which has not run since one of its dependencies did not succeed.
+	StepSkipped = "skipped"
+)
+
+type WorkflowStep struct {
+	Name      string
+	DependsOn []string
+	// Command creates the command of the step when it runs. The workflow id is given so that
+	// records of the step can be related to the workflow.
+	Command func(workflowId string) (*Command, error)
+}
+
+// Workflow runs steps as separate commands in order of their dependencies. Progress of the
+// steps is reported as output of the workflow, and the workflow succeeds when all the steps
+// succeed.
+type Workflow struct {
+	id          string
+	name        string
+	steps       []*WorkflowStep
+	parallelism int
+	reporters   report.ReporterList
+}
+
+// NewWorkflow returns an error if the steps have unknown dependencies or cycles. Parallelism
+// 0 means no limit.
+func NewWorkflow(name string, steps []*WorkflowStep, parallelism int, reporterConfig *report.ReporterConfig) (*Workflow, error) {
+	if err := ValidateWorkflow(steps); err != nil {
+		return nil, err
+	}
+
+	id := generateId()
+	reporters, err := report.NewReporterList(id, name, 1, reporterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize reporter: %s", err)
+	}
+	return &Workflow{id, name, steps, parallelism, reporters}, nil
+}
+
+// ValidateWorkflow checks that step names are unique, dependencies exist and there is no cycle.
+func ValidateWorkflow(steps []*WorkflowStep) error {
+	byName := make(map[string]*WorkflowStep)
+	for _, s := range steps {
+		if _, ok := byName[s.Name]; ok {
+			return fmt.Errorf("step %s is defined more than once", s.Name)
+		}
+		byName[s.Name] = s
+	}
+	for _, s := range steps {
+		for _, d := range s.DependsOn {
+			if _, ok := byName[d]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", s.Name, d)
+			}
+		}
+	}
+
+	// depth first search, where a step on the current path is visiting
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	var path []string
+	var visit func(s *WorkflowStep) error
+	visit = func(s *WorkflowStep) error {
+		switch marks[s.Name] {
+		case visiting:
+			for i, name := range path {
+				if name == s.Name {
+					return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path[i:], " -> "), s.Name)
+				}
+			}
+		case visited:
+			return nil
+		}
+		marks[s.Name] = visiting
+		path = append(path, s.Name)
+		for _, d := range s.DependsOn {
+			if err := visit(byName[d]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[s.Name] = visited
+		return nil
+	}
+	for _, s := range steps {
+		if err := visit(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type stepResult struct {
+	step     *WorkflowStep
+	success  bool
+	err      error
+	duration time.Duration
+}
+
+func (w *Workflow) Start() chan bool {
+	done := make(chan bool)
+	go func() {
+		startAt := time.Now()
+		w.reporters.CommandStart(startAt)
+		w.reporters.StartStdoutLogger(1)
+
+		outcomes := make(map[string]string)
+		started := make(map[string]bool)
+		results := make(chan *stepResult)
+		running := 0
+		for len(outcomes) < len(w.steps) {
+			for _, s := range w.steps {
+				if started[s.Name] {
+					continue
+				}
+				ready, blocker := true, ""
+				for _, d := range s.DependsOn {
+					switch outcomes[d] {
+					case StepSucceeded:
+					case StepFailed, StepSkipped:
+						blocker = d
+					default:
+						ready = false
+					}
+				}
+				if blocker != "" {
+					started[s.Name] = true
+					outcomes[s.Name] = StepSkipped
+					w.log("step %s has been skipped since %s has not succeeded\n", s.Name, blocker)
+					continue
+				}
+				if !ready || (w.parallelism > 0 && running >= w.parallelism) {
+					continue
+				}
+				started[s.Name] = true
+				running++
+				w.log("step %s has started\n", s.Name)
+				go func(s *WorkflowStep) {
+					stepStartAt := time.Now()
+					success, err := w.runStep(s)
+					results <- &stepResult{s, success, err, time.Since(stepStartAt)}
+				}(s)
+			}
+			if running == 0 {
+				// every remaining step has been skipped
+				continue
+			}
+
+			r := <-results
+			running--
+			if r.err != nil {
+				w.log("failed to initialize step %s. %s\n", r.step.Name, r.err)
+			}
+			if r.success {
+				outcomes[r.step.Name] = StepSucceeded
+			} else {
+				outcomes[r.step.Name] = StepFailed
+			}
+			w.log("step %s has %s in %f seconds.\n", r.step.Name, outcomes[r.step.Name], r.duration.Seconds())
+		}
+
+		success := true
+		counts := make(map[string]int)
+		for _, s := range w.steps {
+			counts[outcomes[s.Name]]++
+			success = success && outcomes[s.Name] == StepSucceeded
+		}
+		w.log("%d succeeded, %d failed, %d skipped\n", counts[StepSucceeded], counts[StepFailed], counts[StepSkipped])
+		w.reporters.FinishStdoutLogger()
+
+		endAt := time.Now()
+		if success {
+			w.reporters.CommandSucceed(endAt, endAt.Sub(startAt))
+		} else {
+			w.reporters.CommandFail(endAt, endAt.Sub(startAt))
+		}
+		done <- success
+	}()
+	return done
+}
+
+func (w *Workflow) runStep(s *WorkflowStep) (bool, error) {
+	c, err := s.Command(w.id)
+	if err != nil {
+		return false, err
+	}
+	success := <-c.Start()
+	c.Close()
+	return success, nil
+}
+
+// log must be called from the goroutine of Start, since output is reported in order.
+func (w *Workflow) log(format string, a ...interface{}) {
+	w.reporters.StdoutLog(fmt.Sprintf(format, a...))
+}
+
+func (w *Workflow) Close() {
+	w.reporters.Close()
+}
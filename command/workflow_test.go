@@ -0,0 +1,36 @@
+package command
+
+import (
+	"testing"
+)
+
+func TestValidateWorkflow(t *testing.T) {
+	step := func(name string, deps ...string) *WorkflowStep {
+		return &WorkflowStep{Name: name, DependsOn: deps}
+	}
+	tests := []struct {
+		name  string
+		steps []*WorkflowStep
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"independent", []*WorkflowStep{step("a"), step("b")}, ""},
+		{"diamond", []*WorkflowStep{step("a"), step("b", "a"), step("c", "a"), step("d", "b", "c")}, ""},
+		{"dependency defined later", []*WorkflowStep{step("b", "a"), step("a")}, ""},
+		{"duplicated", []*WorkflowStep{step("a"), step("a")}, "step a is defined more than once"},
+		{"unknown", []*WorkflowStep{step("a", "x")}, "step a depends on unknown step x"},
+		{"self", []*WorkflowStep{step("a", "a")}, "dependency cycle: a -> a"},
+		{"cycle", []*WorkflowStep{step("a", "c"), step("b", "a"), step("c", "b")}, "dependency cycle: a -> c -> b -> a"},
+		{"cycle after a prefix", []*WorkflowStep{step("a", "b"), step("b", "c"), step("c", "b")}, "dependency cycle: b -> c -> b"},
+	}
+	for _, tt := range tests {
+		err := ValidateWorkflow(tt.steps)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -6,26 +6,11 @@ import (
 
 type consoleReporter struct {
 	stringReporter
+	reporterDefaults
 }
 
 func newConsoleReporter(commandId string, commandName string) *consoleReporter {
 	return &consoleReporter{
-		stringReporter{commandId, commandName, os.Stdout, os.Stdout, os.Stderr},
+		stringReporter: stringReporter{commandId: commandId, commandName: commandName, fh: os.Stdout, out: os.Stdout, err: os.Stderr},
 	}
 }
-
-func (r *consoleReporter) startStdoutLogger(count int) {
-	// do nothing
-}
-func (r *consoleReporter) finishStdoutLogger() {
-	// do nothing
-}
-func (r *consoleReporter) startStderrLogger(count int) {
-	// do nothing
-}
-func (r *consoleReporter) finishStderrLogger() {
-	// do nothing
-}
-func (r *consoleReporter) close() {
-	// do nothing
-}
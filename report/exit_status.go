@@ -0,0 +1,16 @@
+package report
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// exitStatus returns the exit code of the process, and the signal number if the process
+// has been killed by a signal. The exit code is -1 in that case.
+func exitStatus(err *exec.ExitError) (code int, signal int, signaled bool) {
+	code = err.ExitCode()
+	if ws, ok := err.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return code, int(ws.Signal()), true
+	}
+	return code, 0, false
+}
@@ -0,0 +1,110 @@
+package report
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const combinedTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// combinedLog writes stdout and stderr of an attempt into one file. Each line is prefixed
+// with the time it was read and its stream, in the order lines were read from the pipes.
+type combinedLog struct {
+	mu       sync.Mutex
+	file     *outputFile
+	finished int
+	partial  map[string]string
+}
+
+// open opens the file for the attempt unless the other stream has already opened it. It
+// returns the file of a previous attempt which is still open, and the caller is responsible
+// to close it.
+func (c *combinedLog) open(path string, count int, limit int64) (*outputFile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file != nil && c.file.count == count {
+		return nil, nil
+	}
+	prev := c.detach()
+	f, err := newOutputFile(path, count, limit)
+	if err != nil {
+		return prev, err
+	}
+	c.file = f
+	c.finished = 0
+	c.partial = make(map[string]string)
+	return prev, nil
+}
+
+func (c *combinedLog) write(stream string, log string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return
+	}
+
+	now := time.Now()
+	log = c.partial[stream] + log
+	for {
+		i := strings.IndexByte(log, '\n')
+		if i < 0 {
+			break
+		}
+		c.writeLine(now, stream, log[:i+1])
+		log = log[i+1:]
+	}
+	c.partial[stream] = log
+}
+
+func (c *combinedLog) writeLine(tm time.Time, stream string, line string) {
+	c.file.Write([]byte(tm.Format(combinedTimeFormat) + " " + stream + " " + line))
+}
+
+// finish flushes a partial line of the stream. It returns the file when both streams have
+// finished, and the caller is responsible to close it. When a stream is filtered out, the
+// file is returned by open of the next attempt or abandon instead. It is not returned when
+// the started streams have finished, since the other stream may start after one has
+// finished.
+func (c *combinedLog) finish(stream string) *outputFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.file == nil {
+		return nil
+	}
+	if partial := c.partial[stream]; partial != "" {
+		c.writeLine(time.Now(), stream, partial+"\n")
+		delete(c.partial, stream)
+	}
+
+	c.finished++
+	if c.finished < 2 {
+		return nil
+	}
+	return c.detach()
+}
+
+// abandon returns the file if it is still open, e.g. when a stream has never started.
+func (c *combinedLog) abandon() *outputFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detach()
+}
+
+func (c *combinedLog) detach() *outputFile {
+	f := c.file
+	if f == nil {
+		return nil
+	}
+	for stream, partial := range c.partial {
+		if partial != "" {
+			c.writeLine(time.Now(), stream, partial+"\n")
+		}
+	}
+	c.file = nil
+	c.partial = nil
+	return f
+}
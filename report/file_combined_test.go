@@ -0,0 +1,57 @@
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCombinedLogFilteredStream(t *testing.T) {
+	dir := t.TempDir()
+	list, err := NewReporterList("id", "name", 2, &ReporterConfig{
+		Reporters: []*ReporterInstance{{
+			Options: &FileConfig{Directory: dir, Combined: true, Compression: CompressionGzip},
+			Filter:  &ReporterFilter{Streams: []string{stdoutTag}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	list.CommandStart(now)
+	for count := 1; count <= 2; count++ {
+		list.StartStdoutLogger(count)
+		list.StartStderrLogger(count)
+		list.AttemptStart(count, 100, now)
+		list.StdoutLog("out\n")
+		list.StderrLog("err\n")
+		list.FinishStdoutLogger()
+		list.FinishStderrLogger()
+		list.AttemptSucceed(count, now, time.Second, nil)
+	}
+	list.CommandSucceed(now, time.Second)
+	list.Close()
+
+	run := &FileRun{dir, "name", "id"}
+	for count := 1; count <= 2; count++ {
+		path := run.CombinedLogPath(count)
+		if _, err := os.Stat(path); err == nil {
+			t.Errorf("combined log of attempt %d has not been compressed", count)
+		}
+		r, err := OpenOutputLog(path)
+		if err != nil {
+			t.Fatalf("attempt %d: %s", count, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n"); len(lines) != 1 || !strings.HasSuffix(lines[0], " stdout out") {
+			t.Errorf("attempt %d has combined log %q, want only stdout", count, b)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The file reporter stores logs with the following layout.
+//
+//	${directory}/${command name}/latest -> ${command id}
+//	${directory}/${command name}/${command id}/command.log
+//	${directory}/${command name}/${command id}/result.json
+//	${directory}/${command name}/${command id}/stdout.log.${attempt}
+//	${directory}/${command name}/${command id}/stderr.log.${attempt}
+//	${directory}/${command name}/${command id}/output.log.${attempt} (optional)
+const (
+	commandLogName    = "command.log"
+	stdoutLogPrefix   = "stdout.log."
+	stderrLogPrefix   = "stderr.log."
+	combinedLogPrefix = "output.log."
+)
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	// RunSkipped is a run which has not started since another instance held the lock.
+	RunSkipped RunStatus = "skipped"
+)
+
+// FileRun is a single run of a command stored by the file reporter.
+type FileRun struct {
+	Directory   string
+	CommandName string
+	CommandId   string
+}
+
+func (r *FileRun) Dir() string {
+	return filepath.Join(r.Directory, r.CommandName, r.CommandId)
+}
+
+func (r *FileRun) CommandLogPath() string {
+	return filepath.Join(r.Dir(), commandLogName)
+}
+
+func (r *FileRun) StdoutLogPath(count int) string {
+	return filepath.Join(r.Dir(), stdoutLogPrefix+strconv.Itoa(count))
+}
+
+func (r *FileRun) StderrLogPath(count int) string {
+	return filepath.Join(r.Dir(), stderrLogPrefix+strconv.Itoa(count))
+}
+
+// CombinedLogPath returns the path of the log where both streams are interleaved.
+func (r *FileRun) CombinedLogPath(count int) string {
+	return filepath.Join(r.Dir(), combinedLogPrefix+strconv.Itoa(count))
+}
+
+// Attempts returns attempt numbers which have any output file, in ascending order.
+func (r *FileRun) Attempts() ([]int, error) {
+	entries, err := ioutil.ReadDir(r.Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	for _, e := range entries {
+		for _, prefix := range []string{stdoutLogPrefix, stderrLogPrefix, combinedLogPrefix} {
+			if !strings.HasPrefix(e.Name(), prefix) {
+				continue
+			}
+			suffix := strings.TrimPrefix(e.Name(), prefix)
+			for _, ext := range compressionExts {
+				suffix = strings.TrimSuffix(suffix, ext)
+			}
+			if n, err := strconv.Atoi(suffix); err == nil {
+				seen[n] = true
+			}
+		}
+	}
+
+	ret := make([]int, 0, len(seen))
+	for n := range seen {
+		ret = append(ret, n)
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+// Status returns the status of the run recorded in result.json. For runs without it, the
+// status is derived from the last lifecycle event in command.log.
+func (r *FileRun) Status() (RunStatus, error) {
+	if result, err := r.Result(); err == nil {
+		return result.Status, nil
+	}
+
+	fh, err := os.Open(r.CommandLogPath())
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	status := RunRunning
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		switch ParseLifecycleLine(scanner.Text()).Event {
+		case commandSucceedTag:
+			status = RunSucceeded
+		case commandFailTag:
+			status = RunFailed
+		case commandLockTag:
+			if strings.Contains(scanner.Text(), "has been skipped") {
+				status = RunSkipped
+			}
+		}
+	}
+	return status, scanner.Err()
+}
+
+// commandIdTimeLen is the length of the start time at the beginning of a command id.
+const commandIdTimeLen = len("20060102-150405")
+
+// FileRuns returns all runs of the command stored under directory, from oldest to newest.
+// Command ids start with their start time in seconds, and runs started in the same second
+// are ordered by the start time in result.json.
+func FileRuns(directory string, commandName string) ([]*FileRun, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(directory, commandName))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*FileRun, 0, len(entries))
+	startAt := make(map[*FileRun]time.Time)
+	for _, e := range entries {
+		if e.IsDir() {
+			run := &FileRun{directory, commandName, e.Name()}
+			ret = append(ret, run)
+			if res, err := run.Result(); err == nil {
+				startAt[run] = res.StartAt
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if len(a.CommandId) >= commandIdTimeLen && len(b.CommandId) >= commandIdTimeLen &&
+			a.CommandId[:commandIdTimeLen] == b.CommandId[:commandIdTimeLen] &&
+			!startAt[a].Equal(startAt[b]) {
+			return startAt[a].Before(startAt[b])
+		}
+		return a.CommandId < b.CommandId
+	})
+	return ret, nil
+}
+
+// FindFileRun returns the run with the given id, or the latest run when commandId is empty.
+func FindFileRun(directory string, commandName string, commandId string) (*FileRun, error) {
+	if commandId != "" {
+		run := &FileRun{directory, commandName, commandId}
+		if _, err := os.Stat(run.CommandLogPath()); err != nil {
+			return nil, err
+		}
+		return run, nil
+	}
+
+	runs, err := FileRuns(directory, commandName)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("no run found for %s in %s", commandName, directory)
+	}
+	return runs[len(runs)-1], nil
+}
+
+// LifecycleEvent is a line of command.log written by stringReporter.
+type LifecycleEvent struct {
+	Line string
+	// Event is one of fluentd tag suffixes such as "attempt_start". It is empty if the line is unknown.
+	Event string
+	// Attempt is 0 for command level events.
+	Attempt int
+	// Lock is the decision of a command_lock event such as "skipped".
+	Lock string
+}
+
+func (e LifecycleEvent) IsAttemptStart() bool {
+	return e.Event == attemptStartTag
+}
+
+// IsCommandEnd reports whether the run has finished, including a run which has not started
+// since another instance has held the lock.
+func (e LifecycleEvent) IsCommandEnd() bool {
+	switch e.Event {
+	case commandSucceedTag, commandFailTag:
+		return true
+	case commandLockTag:
+		return e.Lock == LockSkipped || e.Lock == LockWaitTimeout
+	}
+	return false
+}
+
+var lifecyclePatterns = []struct {
+	event   string
+	lock    string
+	pattern *regexp.Regexp
+}{
+	{commandLockTag, LockSkipped, regexp.MustCompile(`\) The command has been skipped due to running instance`)},
+	{commandLockTag, LockWaitTimeout, regexp.MustCompile(`\) The command has been skipped since running instance has not finished`)},
+	{commandLockTag, LockWaited, regexp.MustCompile(`\) The command has waited for running instance`)},
+	{commandLockTag, LockKilled, regexp.MustCompile(`\) The command has killed running instance`)},
+	{commandStartTag, "", regexp.MustCompile(`\) The command has started(?:$| as a catch-up)`)},
+	{commandSucceedTag, "", regexp.MustCompile(`\) The command has finished with success`)},
+	{commandFailTag, "", regexp.MustCompile(`\) The command has finished with failure`)},
+	{attemptStartTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has started\.`)},
+	{attemptSucceedTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has finished with success`)},
+	{attemptFailTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has failed in`)},
+	{attemptTimeoutTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has been killed due to timeout`)},
+	{attemptOOMTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has been killed due to out of memory`)},
+	{attemptCriteriaFailTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has not met the success criteria`)},
+	{attemptUnknownErrorTag, "", regexp.MustCompile(`\) The (\d+)(?:st|nd|rd|th) attempt has failed with unknown error`)},
+}
+
+func ParseLifecycleLine(line string) LifecycleEvent {
+	ev := LifecycleEvent{Line: line}
+	for _, p := range lifecyclePatterns {
+		m := p.pattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ev.Event = p.event
+		ev.Lock = p.lock
+		if len(m) > 1 {
+			ev.Attempt, _ = strconv.Atoi(m[1])
+		}
+		break
+	}
+	return ev
+}
@@ -0,0 +1,56 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLifecycleLine(t *testing.T) {
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		report  func(r *stringReporter)
+		event   string
+		attempt int
+		lock    string
+		end     bool
+	}{
+		{"start", func(r *stringReporter) { r.commandStart(at) }, commandStartTag, 0, "", false},
+		{"catch-up start", func(r *stringReporter) {
+			r.setCatchUpOf(at.Add(-time.Hour))
+			r.commandStart(at)
+		}, commandStartTag, 0, "", false},
+		{"succeed", func(r *stringReporter) { r.commandSucceed(at, time.Second) }, commandSucceedTag, 0, "", true},
+		{"fail", func(r *stringReporter) { r.commandFail(at, time.Second) }, commandFailTag, 0, "", true},
+		{"lock skipped", func(r *stringReporter) { r.commandLock(LockSkipped, 10, at, 0) }, commandLockTag, 0, LockSkipped, true},
+		{"lock wait timeout", func(r *stringReporter) { r.commandLock(LockWaitTimeout, 10, at, time.Second) }, commandLockTag, 0, LockWaitTimeout, true},
+		{"lock waited", func(r *stringReporter) { r.commandLock(LockWaited, 10, at, time.Second) }, commandLockTag, 0, LockWaited, false},
+		{"lock killed", func(r *stringReporter) { r.commandLock(LockKilled, 10, at, time.Second) }, commandLockTag, 0, LockKilled, false},
+		{"attempt start", func(r *stringReporter) { r.attemptStart(2, 10, at) }, attemptStartTag, 2, "", false},
+		{"attempt succeed", func(r *stringReporter) { r.attemptSucceed(1, at, time.Second, nil) }, attemptSucceedTag, 1, "", false},
+		{"attempt timeout", func(r *stringReporter) { r.attemptTimeout(3, at, time.Second, nil) }, attemptTimeoutTag, 3, "", false},
+		{"attempt criteria fail", func(r *stringReporter) {
+			r.attemptCriteriaFail(11, "exit code 0 is not accepted", at, time.Second, nil)
+		}, attemptCriteriaFailTag, 11, "", false},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		tt.report(&stringReporter{commandId: "id", commandName: "name", fh: &buf})
+		ev := ParseLifecycleLine(strings.TrimRight(buf.String(), "\n"))
+		if ev.Event != tt.event || ev.Attempt != tt.attempt || ev.Lock != tt.lock {
+			t.Errorf("%s: got event %q, attempt %d, lock %q, want %q, %d, %q", tt.name, ev.Event, ev.Attempt, ev.Lock, tt.event, tt.attempt, tt.lock)
+		}
+		if ev.IsCommandEnd() != tt.end {
+			t.Errorf("%s: IsCommandEnd() = %v, want %v", tt.name, ev.IsCommandEnd(), tt.end)
+		}
+	}
+}
+
+func TestParseLifecycleLineUnknown(t *testing.T) {
+	ev := ParseLifecycleLine("some output of the command")
+	if ev.Event != "" || ev.IsCommandEnd() {
+		t.Errorf("got event %q for an unknown line", ev.Event)
+	}
+}
@@ -0,0 +1,192 @@
+package report
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var compressionExts = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+func validateCompression(compression string) error {
+	if _, ok := compressionExts[compression]; !ok && compression != CompressionNone {
+		return fmt.Errorf("unknown compression: %s", compression)
+	}
+	return nil
+}
+
+// outputFile writes an output stream of an attempt. When limit is positive, only the first
+// and the last limit bytes are kept and the rest is replaced with a truncation marker.
+type outputFile struct {
+	path    string
+	count   int
+	fh      *os.File
+	limit   int64
+	written int64
+	tail    []byte
+	dropped int64
+}
+
+func newOutputFile(path string, count int, limit int64) (*outputFile, error) {
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{path: path, count: count, fh: fh, limit: limit}, nil
+}
+
+func (f *outputFile) Write(p []byte) (int, error) {
+	n := len(p)
+	if f.limit <= 0 || f.written < f.limit {
+		head := p
+		if f.limit > 0 && int64(len(p)) > f.limit-f.written {
+			head = p[:f.limit-f.written]
+		}
+		w, err := f.fh.Write(head)
+		f.written += int64(w)
+		if err != nil {
+			return w, err
+		}
+		p = p[len(head):]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+
+	// keep the last limit bytes. The buffer is compacted only when it has doubled so
+	// that chatty streams do not copy the tail for every write.
+	f.tail = append(f.tail, p...)
+	if int64(len(f.tail)) >= 2*f.limit {
+		f.compactTail()
+	}
+	return n, nil
+}
+
+func (f *outputFile) compactTail() {
+	if over := int64(len(f.tail)) - f.limit; over > 0 {
+		f.dropped += over
+		f.tail = append(f.tail[:0], f.tail[over:]...)
+	}
+}
+
+// close writes the kept tail, syncs the file and returns the number of dropped bytes.
+func (f *outputFile) close() (int64, error) {
+	f.compactTail()
+	if f.dropped > 0 {
+		fmt.Fprintf(f.fh, "\n[go-job: %d bytes dropped]\n", f.dropped)
+	}
+	_, err := f.fh.Write(f.tail)
+	f.tail = nil
+	if err == nil {
+		err = f.fh.Sync()
+	}
+	if cerr := f.fh.Close(); err == nil {
+		err = cerr
+	}
+	return f.dropped, err
+}
+
+// compressFile replaces the file at path with its compressed version.
+func compressFile(path string, compression string) error {
+	dst := path + compressionExts[compression]
+	tmp := dst + ".tmp"
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	var w io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(out)
+	case CompressionZstd:
+		if w, err = zstd.NewWriter(out); err != nil {
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	_, err = io.Copy(w, src)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+type decompressReader struct {
+	io.Reader
+	fh     *os.File
+	closer func()
+}
+
+func (r *decompressReader) Close() error {
+	if r.closer != nil {
+		r.closer()
+	}
+	return r.fh.Close()
+}
+
+// OpenOutputLog opens an output log written by the file reporter. path is the name of the
+// uncompressed file and a compressed one is looked up when it does not exist.
+func OpenOutputLog(path string) (io.ReadCloser, error) {
+	fh, err := os.Open(path)
+	if err == nil {
+		return fh, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if fh, err := os.Open(path + compressionExts[CompressionGzip]); err == nil {
+		zr, err := gzip.NewReader(fh)
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+		return &decompressReader{zr, fh, func() { zr.Close() }}, nil
+	}
+
+	if fh, err := os.Open(path + compressionExts[CompressionZstd]); err == nil {
+		zr, err := zstd.NewReader(fh)
+		if err != nil {
+			fh.Close()
+			return nil, err
+		}
+		return &decompressReader{zr, fh, zr.Close}, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,68 @@
+package report
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFileLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int64
+		writes  []string
+		want    string
+		dropped int64
+	}{
+		{"no limit", 0, []string{"abc", "def"}, "abcdef", 0},
+		{"within limit", 4, []string{"ab", "cd"}, "abcd", 0},
+		{"head and tail", 4, []string{"abcdefgh"}, "abcdefgh", 0},
+		{"dropped in a write", 2, []string{"abcdefgh"}, "ab\n[go-job: 4 bytes dropped]\ngh", 4},
+		{"dropped across writes", 3, []string{"ab", "cdef", "ghij", "k"}, "abc\n[go-job: 5 bytes dropped]\nijk", 5},
+		{"tail compacted many times", 1, []string{"a", "b", "c", "d", "e", "f"}, "a\n[go-job: 4 bytes dropped]\nf", 4},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "stdout.log")
+		f, err := newOutputFile(path, 1, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range tt.writes {
+			if n, err := f.Write([]byte(w)); err != nil || n != len(w) {
+				t.Fatalf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+		}
+		dropped, err := f.close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want || dropped != tt.dropped {
+			t.Errorf("%s: got %q, %d dropped, want %q, %d dropped", tt.name, b, dropped, tt.want, tt.dropped)
+		}
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		path := filepath.Join(t.TempDir(), "stdout.log")
+		if err := ioutil.WriteFile(path, []byte("hello\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := compressFile(path, compression); err != nil {
+			t.Fatalf("%s: %s", compression, err)
+		}
+		r, err := OpenOutputLog(path)
+		if err != nil {
+			t.Fatalf("%s: %s", compression, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || string(b) != "hello\n" {
+			t.Errorf("%s: got %q, %v", compression, b, err)
+		}
+	}
+}
@@ -1,74 +1,415 @@
 package report
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// syncWriter serializes writes and allows to swap the underlying writer while other
+// goroutines are writing. Writes are discarded while no writer is set.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+func (s *syncWriter) set(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w = w
+}
+
 type fileReporter struct {
 	stringReporter
-	directory string
-}
+	run         *FileRun
+	retention   *RetentionPolicy
+	compression string
+	outputLimit int64
+	combine     bool
 
-func newFileReporter(commandId string, commandName string, directory string) (*fileReporter, error) {
-	logPath := fmt.Sprintf("%s/%s/%s/command.log", directory, commandName, commandId)
+	owner *FileOwner
+	// the run directory and command.log are created at the first event, which comes after
+	// a lock is taken
+	createOnce  sync.Once
+	createErr   error
+	commandLog  *os.File
+	commandLogW *syncWriter
+	stdout      *outputFile
+	stderr      *outputFile
+	stdoutW     *syncWriter
+	stderrW     *syncWriter
+	combined    combinedLog
+	compressing sync.WaitGroup
 
-	err := os.MkdirAll(path.Dir(logPath), 0755)
-	if err != nil {
+	resultMu sync.Mutex
+	result   *FileRunResult
+}
+
+func newFileReporter(commandId string, commandName string, config *FileConfig, labels map[string]string, owner *FileOwner) (*fileReporter, error) {
+	if err := validateCompression(config.Compression); err != nil {
 		return nil, err
 	}
 
-	fh, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE, 0644)
+	run := &FileRun{config.Directory, commandName, commandId}
 
+	// the directory of the command is shared by runs, so it can be created before a lock is taken
+	err := os.MkdirAll(filepath.Dir(run.Dir()), 0755)
 	if err != nil {
 		return nil, err
 	}
 
+	hostname, _ := os.Hostname()
+
+	stdoutW, stderrW := &syncWriter{}, &syncWriter{}
 	fr := &fileReporter{
-		stringReporter{
+		stringReporter: stringReporter{
 			commandId:   commandId,
 			commandName: commandName,
-			fh:          fh,
-		}, directory}
+			out:         stdoutW,
+			err:         stderrW,
+		},
+		run:         run,
+		retention:   &config.Retention,
+		compression: config.Compression,
+		outputLimit: config.OutputLimit,
+		combine:     config.Combined,
+		owner:       owner,
+		commandLogW: &syncWriter{},
+		stdoutW:     stdoutW,
+		stderrW:     stderrW,
+		result: &FileRunResult{
+			CommandId:   commandId,
+			CommandName: commandName,
+			Hostname:    hostname,
+			Status:      RunRunning,
+			Labels:      labels,
+			Attempts:    []*AttemptResult{},
+		},
+	}
 
+	fr.fh = commandLogWriter{fr}
 	return fr, nil
 }
 
-func (r *fileReporter) startStdoutLogger(count int) {
-	path := fmt.Sprintf("%s/%s/%s/stdout.log.%d", r.directory, r.commandName, r.commandId, count)
-	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+// commandLogWriter creates the run at the first message written to command.log.
+type commandLogWriter struct {
+	r *fileReporter
+}
+
+func (w commandLogWriter) Write(p []byte) (int, error) {
+	w.r.createRun()
+	return w.r.commandLogW.Write(p)
+}
+
+// createRun creates the run directory and command.log, and points the latest link to the run.
+func (r *fileReporter) createRun() error {
+	r.createOnce.Do(func() {
+		r.createErr = r.doCreateRun()
+		if r.createErr != nil {
+			fmt.Fprintf(os.Stderr, "failed to create %s. %s\n", r.run.Dir(), r.createErr)
+		}
+	})
+	return r.createErr
+}
+
+func (r *fileReporter) doCreateRun() error {
+	if err := os.MkdirAll(r.run.Dir(), 0755); err != nil {
+		return err
+	}
+	r.chown(filepath.Dir(r.run.Dir()))
+	r.chown(r.run.Dir())
+
+	// command.log is only appended to, so each line is written atomically
+	fh, err := os.OpenFile(r.run.CommandLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.commandLog = fh
+	r.commandLogW.set(fh)
+	r.chown(r.run.CommandLogPath())
+
+	if err := updateLatestLink(r.run); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to update the latest link. %s\n", err)
+	} else {
+		r.chown(filepath.Join(r.run.Directory, r.run.CommandName, latestLinkName))
+	}
+	return nil
+}
+
+// chown makes the file owned by the user running the command, so that the user can read
+// and prune runs of the command. Links are changed themselves.
+func (r *fileReporter) chown(path string) {
+	if r.owner == nil {
+		return
+	}
+	if err := os.Lchown(path, r.owner.Uid, r.owner.Gid); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to change the owner of %s. %s\n", path, err)
+	}
+}
+
+func (r *fileReporter) updateResult(f func(res *FileRunResult)) {
+	if r.createRun() != nil {
+		return
+	}
+	r.resultMu.Lock()
+	defer r.resultMu.Unlock()
+
+	f(r.result)
+	b, err := json.MarshalIndent(r.result, "", "  ")
 	if err == nil {
-		r.out = fh
+		err = writeFileAtomically(r.run.ResultPath(), append(b, '\n'))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s. %s\n", r.run.ResultPath(), err)
+		return
 	}
+	// the file is replaced on each update
+	r.chown(r.run.ResultPath())
 }
 
-func (r *fileReporter) finishStdoutLogger() {
-	if r.out != nil {
-		if fh, ok := r.out.(*os.File); ok {
-			fh.Close()
+func (r *fileReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.stringReporter.commandLock(decision, holderPid, at, waited)
+	r.updateResult(func(res *FileRunResult) {
+		res.Lock = &LockResult{decision, holderPid, waited.Seconds()}
+		if decision == LockSkipped || decision == LockWaitTimeout {
+			res.Status = RunSkipped
+			res.StartAt = at
+			res.EndAt = &at
 		}
+	})
+}
+
+func (r *fileReporter) commandStart(startAt time.Time) {
+	r.stringReporter.commandStart(startAt)
+	r.updateResult(func(res *FileRunResult) {
+		res.StartAt = startAt
+	})
+}
+
+func (r *fileReporter) commandSucceed(endAt time.Time, duration time.Duration) {
+	r.stringReporter.commandSucceed(endAt, duration)
+	r.finishResult(RunSucceeded, endAt, duration)
+}
+
+func (r *fileReporter) commandFail(endAt time.Time, duration time.Duration) {
+	r.stringReporter.commandFail(endAt, duration)
+	r.finishResult(RunFailed, endAt, duration)
+}
+
+func (r *fileReporter) finishResult(status RunStatus, endAt time.Time, duration time.Duration) {
+	r.updateResult(func(res *FileRunResult) {
+		res.Status = status
+		res.EndAt = &endAt
+		res.Duration = duration.Seconds()
+	})
+}
+
+func (r *fileReporter) attemptStart(count int, pid int, startAt time.Time) {
+	r.stringReporter.attemptStart(count, pid, startAt)
+	r.updateResult(func(res *FileRunResult) {
+		a := res.attempt(count)
+		a.Pid = pid
+		a.StartAt = &startAt
+	})
+}
+
+func (r *fileReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.stringReporter.attemptSucceed(count, endAt, duration, usage)
+	r.finishAttempt(count, AttemptSucceeded, endAt, duration, nil, usage)
+}
+
+func (r *fileReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.stringReporter.attemptFail(count, err, endAt, duration, usage)
+	r.finishAttempt(count, AttemptFailed, endAt, duration, err, usage)
+}
+
+func (r *fileReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.stringReporter.attemptTimeout(count, endAt, duration, usage)
+	r.finishAttempt(count, AttemptTimedOut, endAt, duration, nil, usage)
+}
+
+func (r *fileReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.stringReporter.attemptOOM(count, err, endAt, duration, usage)
+	r.finishAttempt(count, AttemptOutOfMemory, endAt, duration, err, usage)
+}
+
+func (r *fileReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.stringReporter.attemptCriteriaFail(count, criterion, endAt, duration, usage)
+	r.finishAttempt(count, AttemptCriteriaFailed, endAt, duration, errors.New(criterion), usage)
+}
+
+func (r *fileReporter) attemptUnknownError(count int, err error, endAt time.Time) {
+	r.stringReporter.attemptUnknownError(count, err, endAt)
+	r.finishAttempt(count, AttemptUnknownError, endAt, 0, err, nil)
+}
+
+func (r *fileReporter) finishAttempt(count int, result string, endAt time.Time, duration time.Duration, err error, usage *ResourceUsage) {
+	r.updateResult(func(res *FileRunResult) {
+		a := res.attempt(count)
+		a.Result = result
+		a.EndAt = &endAt
+		a.Duration = duration.Seconds()
+		a.Usage = usage
+		if err != nil {
+			a.Error = err.Error()
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			code, signal, signaled := exitStatus(exitErr)
+			a.ExitCode = &code
+			if signaled {
+				a.Signal = &signal
+			}
+		}
+	})
+}
+
+func (r *fileReporter) startStdoutLogger(count int) {
+	if r.createRun() != nil {
+		return
+	}
+	// a new attempt always starts with an empty file
+	f, err := newOutputFile(r.run.StdoutLogPath(count), count, r.outputLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open a stdout log. %s\n", err)
+		return
+	}
+	r.chown(f.path)
+	r.stdout = f
+	r.stdoutW.set(f)
+	r.startCombinedLog(count)
+}
+
+func (r *fileReporter) finishStdoutLogger() {
+	if r.stdout != nil {
+		r.stdoutW.set(nil)
+		dropped := r.finishOutput(r.stdout, "stdout")
+		count := r.stdout.count
+		r.updateResult(func(res *FileRunResult) {
+			res.attempt(count).StdoutDropped = dropped
+		})
+		r.stdout = nil
+	}
+	r.finishCombinedLog(r.combined.finish(stdoutTag))
+}
+
+func (r *fileReporter) stdoutLog(log string) {
+	r.stringReporter.stdoutLog(log)
+	if r.combine {
+		r.combined.write(stdoutTag, log)
 	}
 }
 
 func (r *fileReporter) startStderrLogger(count int) {
-	path := fmt.Sprintf("%s/%s/%s/stderr.log.%d", r.directory, r.commandName, r.commandId, count)
-	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
-	if err == nil {
-		r.err = fh
+	if r.createRun() != nil {
+		return
 	}
+	f, err := newOutputFile(r.run.StderrLogPath(count), count, r.outputLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open a stderr log. %s\n", err)
+		return
+	}
+	r.chown(f.path)
+	r.stderr = f
+	r.stderrW.set(f)
+	r.startCombinedLog(count)
 }
 
 func (r *fileReporter) finishStderrLogger() {
-	if r.err != nil {
-		if fh, ok := r.err.(*os.File); ok {
-			fh.Close()
-		}
+	if r.stderr != nil {
+		r.stderrW.set(nil)
+		dropped := r.finishOutput(r.stderr, "stderr")
+		count := r.stderr.count
+		r.updateResult(func(res *FileRunResult) {
+			res.attempt(count).StderrDropped = dropped
+		})
+		r.stderr = nil
+	}
+	r.finishCombinedLog(r.combined.finish(stderrTag))
+}
+
+func (r *fileReporter) stderrLog(log string) {
+	r.stringReporter.stderrLog(log)
+	if r.combine {
+		r.combined.write(stderrTag, log)
+	}
+}
+
+func (r *fileReporter) startCombinedLog(count int) {
+	if !r.combine {
+		return
+	}
+	prev, err := r.combined.open(r.run.CombinedLogPath(count), count, r.outputLimit)
+	r.finishCombinedLog(prev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open a combined log. %s\n", err)
+		return
+	}
+	r.chown(r.run.CombinedLogPath(count))
+}
+
+func (r *fileReporter) finishCombinedLog(f *outputFile) {
+	if f == nil {
+		return
 	}
+	dropped := r.finishOutput(f, "combined output")
+	r.updateResult(func(res *FileRunResult) {
+		res.attempt(f.count).CombinedDropped = dropped
+	})
+}
+
+func (r *fileReporter) finishOutput(f *outputFile, stream string) int64 {
+	dropped, err := f.close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s. %s\n", f.path, err)
+	}
+	if dropped > 0 {
+		r.write(time.Now(), "The %s of the %s attempt has been truncated. %d bytes dropped.\n", stream, ordinalize(f.count), dropped)
+	}
+
+	if r.compression != CompressionNone {
+		// compression of a large output must not delay the next attempt
+		r.compressing.Add(1)
+		go func() {
+			defer r.compressing.Done()
+			if err := compressFile(f.path, r.compression); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to compress %s. %s\n", f.path, err)
+				return
+			}
+			r.chown(f.path + compressionExts[r.compression])
+		}()
+	}
+	return dropped
 }
 
 func (r *fileReporter) close() {
-	if fh, ok := r.fh.(*os.File); ok {
-		fh.Close()
+	r.finishCombinedLog(r.combined.abandon())
+	r.compressing.Wait()
+
+	if r.commandLog != nil {
+		if err := r.commandLog.Sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to sync %s. %s\n", r.run.CommandLogPath(), err)
+		}
+		r.commandLog.Close()
+	}
+
+	if r.retention.enabled() {
+		if _, err := PruneFileRuns(r.run.Directory, r.commandName, r.retention, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to prune old runs. %s\n", err)
+		}
 	}
 }
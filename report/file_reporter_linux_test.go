@@ -0,0 +1,56 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileReporterOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner requires root")
+	}
+	dir := t.TempDir()
+	owner := &FileOwner{Uid: 1234, Gid: 5678}
+	list, err := NewReporterList("id", "name", 1, &ReporterConfig{
+		Reporters: []*ReporterInstance{{Options: &FileConfig{Directory: dir, Combined: true, Compression: CompressionGzip}}},
+		FileOwner: owner,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	list.CommandStart(now)
+	list.StartStdoutLogger(1)
+	list.StartStderrLogger(1)
+	list.AttemptStart(1, 100, now)
+	list.StdoutLog("out\n")
+	list.StderrLog("err\n")
+	list.FinishStdoutLogger()
+	list.FinishStderrLogger()
+	list.AttemptSucceed(1, now, time.Second, nil)
+	list.CommandSucceed(now, time.Second)
+	list.Close()
+
+	n := 0
+	err = filepath.Walk(filepath.Join(dir, "name"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st := info.Sys().(*syscall.Stat_t)
+		if int(st.Uid) != owner.Uid || int(st.Gid) != owner.Gid {
+			t.Errorf("%s is owned by %d:%d", path, st.Uid, st.Gid)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the command directory, latest, the run directory, command.log, result.json and 3 outputs
+	if n != 8 {
+		t.Errorf("checked %d files, want 8", n)
+	}
+}
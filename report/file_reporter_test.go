@@ -0,0 +1,108 @@
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestFileReporterSwitchesAttempts writes both streams concurrently while each of them switches
+// to the next attempt on its own, as the goroutines of a command do. Run it with -race.
+func TestFileReporterSwitchesAttempts(t *testing.T) {
+	dir := t.TempDir()
+	r, err := newFileReporter("id", "name", &FileConfig{Directory: dir}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const attempts, lines = 3, 200
+
+	now := time.Now()
+	r.commandStart(now)
+	for count := 1; count <= attempts; count++ {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func(count int) {
+			defer wg.Done()
+			r.startStdoutLogger(count)
+			for i := 0; i < lines; i++ {
+				r.stdoutLog(fmt.Sprintf("out %d\n", count))
+			}
+			r.finishStdoutLogger()
+		}(count)
+		go func(count int) {
+			defer wg.Done()
+			r.startStderrLogger(count)
+			for i := 0; i < lines; i++ {
+				r.stderrLog(fmt.Sprintf("err %d\n", count))
+			}
+			r.finishStderrLogger()
+		}(count)
+		r.attemptStart(count, 100, now)
+		wg.Wait()
+		r.attemptSucceed(count, now, time.Second, nil)
+	}
+	r.commandSucceed(now, time.Second)
+	r.close()
+
+	run := &FileRun{dir, "name", "id"}
+	for count := 1; count <= attempts; count++ {
+		for path, line := range map[string]string{
+			run.StdoutLogPath(count): fmt.Sprintf("out %d\n", count),
+			run.StderrLogPath(count): fmt.Sprintf("err %d\n", count),
+		} {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != strings.Repeat(line, lines) {
+				t.Errorf("%s has unexpected content of %d bytes", path, len(b))
+			}
+		}
+	}
+	res, err := run.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != RunSucceeded || len(res.Attempts) != attempts {
+		t.Errorf("result has status %s and %d attempts", res.Status, len(res.Attempts))
+	}
+}
+
+func TestFileReporterCreatesRunAfterLock(t *testing.T) {
+	dir := t.TempDir()
+	list, err := NewReporterList("id", "name", 1, &ReporterConfig{
+		Reporters: []*ReporterInstance{{Options: &FileConfig{Directory: dir}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := &FileRun{dir, "name", "id"}
+	link := filepath.Join(dir, "name", latestLinkName)
+
+	if _, err := os.Stat(run.Dir()); !os.IsNotExist(err) {
+		t.Errorf("run directory exists before the lock decision. %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("latest link exists before the lock decision. %v", err)
+	}
+
+	now := time.Now()
+	list.CommandLock(LockSkipped, 100, now, 0)
+	list.Close()
+
+	res, err := run.Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != RunSkipped {
+		t.Errorf("status is %s, want %s", res.Status, RunSkipped)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "id" {
+		t.Errorf("latest link points to %q. %v", target, err)
+	}
+}
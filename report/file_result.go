@@ -0,0 +1,128 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	resultFileName = "result.json"
+	latestLinkName = "latest"
+)
+
+const (
+	AttemptRunning        = "running"
+	AttemptSucceeded      = "succeeded"
+	AttemptFailed         = "failed"
+	AttemptTimedOut       = "timeout"
+	AttemptOutOfMemory    = "oom"
+	AttemptCriteriaFailed = "criteria_failed"
+	AttemptUnknownError   = "unknown_error"
+)
+
+// FileRunResult is a machine-readable summary of a run written to result.json.
+type FileRunResult struct {
+	CommandId   string            `json:"commandId"`
+	CommandName string            `json:"commandName"`
+	Hostname    string            `json:"hostname"`
+	Status      RunStatus         `json:"status"`
+	StartAt     time.Time         `json:"startAt"`
+	EndAt       *time.Time        `json:"endAt,omitempty"`
+	Duration    float64           `json:"duration,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Attempts    []*AttemptResult  `json:"attempts"`
+	Lock        *LockResult       `json:"lock,omitempty"`
+}
+
+// LockResult is the decision when another instance of the command held the lock.
+type LockResult struct {
+	Decision  string  `json:"decision"`
+	HolderPid int     `json:"holderPid,omitempty"`
+	Waited    float64 `json:"waited,omitempty"`
+}
+
+type AttemptResult struct {
+	Count           int            `json:"count"`
+	Pid             int            `json:"pid,omitempty"`
+	StartAt         *time.Time     `json:"startAt,omitempty"`
+	EndAt           *time.Time     `json:"endAt,omitempty"`
+	Duration        float64        `json:"duration,omitempty"`
+	Result          string         `json:"result"`
+	ExitCode        *int           `json:"exitCode,omitempty"`
+	Signal          *int           `json:"signal,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	StdoutDropped   int64          `json:"stdoutDropped,omitempty"`
+	StderrDropped   int64          `json:"stderrDropped,omitempty"`
+	CombinedDropped int64          `json:"combinedDropped,omitempty"`
+	Usage           *ResourceUsage `json:"usage,omitempty"`
+}
+
+func (r *FileRun) ResultPath() string {
+	return filepath.Join(r.Dir(), resultFileName)
+}
+
+// Result reads result.json of the run.
+func (r *FileRun) Result() (*FileRunResult, error) {
+	b, err := ioutil.ReadFile(r.ResultPath())
+	if err != nil {
+		return nil, err
+	}
+	result := &FileRunResult{}
+	if err := json.Unmarshal(b, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (res *FileRunResult) attempt(count int) *AttemptResult {
+	for _, a := range res.Attempts {
+		if a.Count == count {
+			return a
+		}
+	}
+	a := &AttemptResult{Count: count, Result: AttemptRunning}
+	res.Attempts = append(res.Attempts, a)
+	return a
+}
+
+// writeFileAtomically writes data to a temporary file and renames it to path, so that readers
+// never see a partially written file.
+func writeFileAtomically(path string, data []byte) error {
+	tmp := path + ".tmp"
+	fh, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// updateLatestLink points ${directory}/${command name}/latest to the run.
+func updateLatestLink(run *FileRun) error {
+	link := filepath.Join(run.Directory, run.CommandName, latestLinkName)
+	tmp := link + "." + run.CommandId + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(run.CommandId, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
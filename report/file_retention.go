@@ -0,0 +1,143 @@
+package report
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RetentionPolicy decides which runs of a command stored by the file reporter are removed.
+// A zero value of each field means no limit. The latest run, the most recent failed run and
+// runs still in progress within MaxAge are always kept.
+type RetentionPolicy struct {
+	KeepRuns int
+	MaxAge   time.Duration
+	MaxSize  int64
+}
+
+func (p *RetentionPolicy) enabled() bool {
+	return p != nil && (p.KeepRuns > 0 || p.MaxAge > 0 || p.MaxSize > 0)
+}
+
+// FileCommandNames returns names of commands which have runs under directory.
+func FileCommandNames(directory string) ([]string, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			ret = append(ret, e.Name())
+		}
+	}
+	return ret, nil
+}
+
+// Size returns the total size of files of the run.
+func (r *FileRun) Size() (int64, error) {
+	var size int64
+	err := filepath.Walk(r.Dir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// LastModified returns the time when command.log was last written, i.e. the end of the run
+// for a finished run.
+func (r *FileRun) LastModified() (time.Time, error) {
+	info, err := os.Stat(r.CommandLogPath())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func (r *FileRun) Remove() error {
+	return os.RemoveAll(r.Dir())
+}
+
+// SelectFileRunsToPrune returns runs of the command which violate the policy, from oldest to newest.
+func SelectFileRunsToPrune(directory string, commandName string, policy *RetentionPolicy, now time.Time) ([]*FileRun, error) {
+	if !policy.enabled() {
+		return nil, nil
+	}
+
+	runs, err := FileRuns(directory, commandName)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ret        []*FileRun
+		kept       int
+		totalSize  int64
+		keptFailed bool
+	)
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+
+		status, err := run.Status()
+		if err != nil {
+			// a directory without command.log is not a run, or is being created right now
+			continue
+		}
+		modified, err := run.LastModified()
+		if err != nil {
+			return nil, err
+		}
+		size, err := run.Size()
+		if err != nil {
+			return nil, err
+		}
+
+		expired := policy.MaxAge > 0 && now.Sub(modified) > policy.MaxAge
+		// the latest run is usually the one which has just finished
+		protected := i == len(runs)-1
+		switch status {
+		case RunRunning:
+			protected = protected || !expired
+		case RunFailed:
+			protected = protected || !keptFailed
+			keptFailed = true
+		}
+
+		remove := expired ||
+			(policy.KeepRuns > 0 && kept >= policy.KeepRuns) ||
+			(policy.MaxSize > 0 && totalSize+size > policy.MaxSize)
+
+		if remove && !protected {
+			ret = append([]*FileRun{run}, ret...)
+			continue
+		}
+		kept++
+		totalSize += size
+	}
+	return ret, nil
+}
+
+// PruneFileRuns removes runs of the command which violate the policy and returns removed runs.
+func PruneFileRuns(directory string, commandName string, policy *RetentionPolicy, now time.Time) ([]*FileRun, error) {
+	runs, err := SelectFileRunsToPrune(directory, commandName, policy, now)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]*FileRun, 0, len(runs))
+	for _, run := range runs {
+		if err := run.Remove(); err != nil {
+			return removed, fmt.Errorf("failed to remove %s. %s", run.Dir(), err)
+		}
+		removed = append(removed, run)
+	}
+	return removed, nil
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestRuns writes runs of a command, from oldest to newest, whose command.log was last
+// written the given hours before now.
+func writeTestRuns(t *testing.T, dir string, now time.Time, runs []testRun) {
+	for _, tr := range runs {
+		run := &FileRun{dir, "name", tr.id}
+		if err := os.MkdirAll(run.Dir(), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		r := &stringReporter{commandId: tr.id, commandName: "name", fh: &buf}
+		modified := now.Add(-time.Duration(tr.hours) * time.Hour)
+		r.commandStart(modified)
+		switch tr.status {
+		case RunSucceeded:
+			r.commandSucceed(modified, time.Second)
+		case RunFailed:
+			r.commandFail(modified, time.Second)
+		}
+		if err := ioutil.WriteFile(run.CommandLogPath(), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(run.StdoutLogPath(1), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(run.CommandLogPath(), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type testRun struct {
+	id     string
+	status RunStatus
+	hours  int
+}
+
+func TestSelectFileRunsToPrune(t *testing.T) {
+	runs := []testRun{
+		{"20260101-000000-00000001", RunSucceeded, 50},
+		{"20260101-000000-00000002", RunFailed, 40},
+		{"20260101-000000-00000003", RunSucceeded, 30},
+		{"20260101-000000-00000004", RunFailed, 20},
+		{"20260101-000000-00000005", RunRunning, 10},
+		{"20260101-000000-00000006", RunSucceeded, 1},
+	}
+	tests := []struct {
+		name   string
+		policy RetentionPolicy
+		want   []string
+	}{
+		{"disabled", RetentionPolicy{}, nil},
+		// the latest run, the running one and the most recent failed one are kept
+		{"keep runs", RetentionPolicy{KeepRuns: 1}, []string{"1", "2", "3"}},
+		{"keep more runs", RetentionPolicy{KeepRuns: 4}, []string{"1", "2"}},
+		{"max age", RetentionPolicy{MaxAge: 25 * time.Hour}, []string{"1", "2", "3"}},
+		{"expired running run", RetentionPolicy{MaxAge: 5 * time.Hour}, []string{"1", "2", "3", "5"}},
+		{"max size", RetentionPolicy{MaxSize: 5000}, []string{"1", "2"}},
+	}
+	dir := t.TempDir()
+	now := time.Now()
+	writeTestRuns(t, dir, now, runs)
+	for _, tt := range tests {
+		selected, err := SelectFileRunsToPrune(dir, "name", &tt.policy, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, run := range selected {
+			got = append(got, strings.TrimLeft(run.CommandId[len("20260101-000000-"):], "0"))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: selected %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPruneFileRuns(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTestRuns(t, dir, now, []testRun{
+		{"20260101-000000-00000001", RunSucceeded, 3},
+		{"20260101-000000-00000002", RunSucceeded, 2},
+		{"20260101-000000-00000003", RunSucceeded, 1},
+	})
+	removed, err := PruneFileRuns(dir, "name", &RetentionPolicy{KeepRuns: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %d runs, want 2", len(removed))
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "20260101-000000-00000003" {
+		t.Errorf("%d runs are left", len(entries))
+	}
+}
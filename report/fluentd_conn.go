@@ -0,0 +1,288 @@
+package report
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	fluentdDialTimeout       = 3 * time.Second
+	fluentdHandshakeTimeout  = 10 * time.Second
+	fluentdDefaultAckTimeout = 30 * time.Second
+	fluentdBatchSize         = 1000
+)
+
+// fluentdConnConfig describes how to connect to fluentd with the forward protocol.
+type fluentdConnConfig struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+
+	// shared key authentication. The handshake is done only when sharedKey is set.
+	sharedKey    string
+	selfHostname string
+	username     string
+	password     string
+
+	requireAck bool
+	ackTimeout time.Duration
+}
+
+func newFluentdConnConfig(config *FluentdConfig, hostname string) (*fluentdConnConfig, error) {
+	c := &fluentdConnConfig{
+		network:      "tcp",
+		address:      net.JoinHostPort(config.Host, strconv.Itoa(config.Port)),
+		sharedKey:    config.SharedKey,
+		selfHostname: hostname,
+		username:     config.Username,
+		password:     config.Password,
+		requireAck:   config.RequireAck,
+		ackTimeout:   config.AckTimeout,
+	}
+	if c.ackTimeout == 0 {
+		c.ackTimeout = fluentdDefaultAckTimeout
+	}
+
+	if config.SocketPath != "" {
+		c.network = "unix"
+		c.address = config.SocketPath
+	}
+
+	if config.TLS {
+		tlsConfig, err := newFluentdTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		c.tlsConfig = tlsConfig
+	}
+	return c, nil
+}
+
+func newFluentdTLSConfig(config *FluentdConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.Host,
+		InsecureSkipVerify: config.TLSInsecureSkipVerify,
+	}
+
+	if config.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// fluentdConn is a connection to fluentd after the handshake.
+type fluentdConn struct {
+	net.Conn
+	config *fluentdConnConfig
+	dec    *msgpack.Decoder
+}
+
+func dialFluentd(config *fluentdConnConfig) (*fluentdConn, error) {
+	dialer := &net.Dialer{Timeout: fluentdDialTimeout}
+
+	var conn net.Conn
+	var err error
+	if config.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, config.network, config.address, config.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(config.network, config.address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &fluentdConn{
+		Conn:   conn,
+		config: config,
+		dec:    msgpack.NewDecoder(bufio.NewReader(conn)),
+	}
+
+	if config.sharedKey != "" {
+		conn.SetDeadline(time.Now().Add(fluentdHandshakeTimeout))
+		if err := c.handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("fluentd handshake failed. %s", err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return c, nil
+}
+
+func sha512Hex(s ...[]byte) string {
+	h := sha512.New()
+	for _, b := range s {
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// bytesOf accepts both str and bin types since fluentd versions differ in which they send.
+func bytesOf(v interface{}) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
+
+// handshake authenticates with a shared key. The server sends HELO, the client answers
+// with PING and the server replies PONG, as defined in the forward protocol v1.
+func (c *fluentdConn) handshake() error {
+	var helo []interface{}
+	if err := c.dec.Decode(&helo); err != nil {
+		return err
+	}
+	if len(helo) < 2 || helo[0] != "HELO" {
+		return fmt.Errorf("unexpected message: %v", helo)
+	}
+	options, _ := helo[1].(map[string]interface{})
+	nonce := bytesOf(options["nonce"])
+	authSalt := bytesOf(options["auth"])
+
+	saltBytes := make([]byte, 16)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return err
+	}
+	salt := []byte(hex.EncodeToString(saltBytes))
+
+	cfg := c.config
+	passwordDigest := ""
+	if len(authSalt) > 0 {
+		passwordDigest = sha512Hex(authSalt, []byte(cfg.username), []byte(cfg.password))
+	}
+	ping := []interface{}{
+		"PING",
+		cfg.selfHostname,
+		string(salt),
+		sha512Hex(salt, []byte(cfg.selfHostname), nonce, []byte(cfg.sharedKey)),
+		cfg.username,
+		passwordDigest,
+	}
+	if err := msgpack.NewEncoder(c.Conn).Encode(ping); err != nil {
+		return err
+	}
+
+	var pong []interface{}
+	if err := c.dec.Decode(&pong); err != nil {
+		return err
+	}
+	if len(pong) < 5 || pong[0] != "PONG" {
+		return fmt.Errorf("unexpected message: %v", pong)
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return fmt.Errorf("authentication failed: %v", pong[2])
+	}
+	serverHostname := bytesOf(pong[3])
+	expected := sha512Hex(salt, serverHostname, nonce, []byte(cfg.sharedKey))
+	if string(bytesOf(pong[4])) != expected {
+		return fmt.Errorf("shared key mismatch with %s", serverHostname)
+	}
+	return nil
+}
+
+type fluentdForwardEntry struct {
+	_msgpack struct{} `msgpack:",as_array"`
+	Time     int64
+	Record   map[string]interface{}
+}
+
+// send writes entries in batches and returns the number of entries which have been
+// written, or acknowledged when ack is required. An entry which cannot be encoded is
+// dropped, since it would never be sent.
+func (c *fluentdConn) send(entries []*fluentdEntry, writeTimeout time.Duration) (int, error) {
+	sent := 0
+	for sent < len(entries) {
+		tag := entries[sent].tag
+		batch := make([]msgpack.RawMessage, 0)
+		n := 0
+		for _, e := range entries[sent:] {
+			if e.tag != tag || n == fluentdBatchSize {
+				break
+			}
+			n++
+			b, err := msgpack.Marshal(&fluentdForwardEntry{Time: e.time.Unix(), Record: e.record})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to encode a fluentd event. %s\n", err)
+				continue
+			}
+			batch = append(batch, b)
+		}
+
+		if err := c.sendBatch(tag, batch, writeTimeout); err != nil {
+			return sent, err
+		}
+		sent += n
+	}
+	return sent, nil
+}
+
+// sendBatch sends entries with the same tag in Forward Mode.
+func (c *fluentdConn) sendBatch(tag string, batch []msgpack.RawMessage, writeTimeout time.Duration) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	msg := []interface{}{tag, batch}
+	var chunk string
+	if c.config.requireAck {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		chunk = base64.StdEncoding.EncodeToString(b)
+		msg = append(msg, map[string]interface{}{"chunk": chunk})
+	}
+
+	b, err := msgpack.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.Write(b); err != nil {
+		return err
+	}
+
+	if !c.config.requireAck {
+		return nil
+	}
+	c.SetReadDeadline(time.Now().Add(c.config.ackTimeout))
+	var res map[string]interface{}
+	if err := c.dec.Decode(&res); err != nil {
+		return fmt.Errorf("failed to receive ack. %s", err)
+	}
+	if ack, _ := res["ack"].(string); ack != chunk {
+		return fmt.Errorf("unexpected ack: %v", res["ack"])
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package report
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// fluentdStandIn accepts connections in the forward protocol, and records received events.
+type fluentdStandIn struct {
+	ln net.Listener
+	// sharedKey enables the handshake. pongKey is used for the PONG digest, which is
+	// sharedKey unless a mismatch is tested.
+	sharedKey string
+	pongKey   string
+
+	mu     sync.Mutex
+	events []string
+}
+
+func newFluentdStandIn(t *testing.T, sharedKey string) *fluentdStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fluentdStandIn{ln: ln, sharedKey: sharedKey, pongKey: sharedKey}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fluentdStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := msgpack.NewDecoder(bufio.NewReader(conn))
+	enc := msgpack.NewEncoder(conn)
+
+	if s.sharedKey != "" {
+		nonce := []byte("nonce")
+		enc.Encode([]interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}})
+		var ping []interface{}
+		if err := dec.Decode(&ping); err != nil || len(ping) < 4 {
+			return
+		}
+		salt := bytesOf(ping[2])
+		if string(bytesOf(ping[3])) != sha512Hex(salt, bytesOf(ping[1]), nonce, []byte(s.sharedKey)) {
+			enc.Encode([]interface{}{"PONG", false, "shared key mismatch", "", ""})
+			return
+		}
+		enc.Encode([]interface{}{"PONG", true, "", "server", sha512Hex(salt, []byte("server"), nonce, []byte(s.pongKey))})
+	}
+
+	for {
+		var msg []msgpack.RawMessage
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		var tag string
+		var entries []fluentdForwardEntry
+		msgpack.Unmarshal(msg[0], &tag)
+		msgpack.Unmarshal(msg[1], &entries)
+		s.mu.Lock()
+		for _, e := range entries {
+			s.events = append(s.events, tag+":"+e.Record["message"].(string))
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *fluentdStandIn) connConfig(sharedKey string) *fluentdConnConfig {
+	return &fluentdConnConfig{
+		network:      "tcp",
+		address:      s.ln.Addr().String(),
+		sharedKey:    sharedKey,
+		selfHostname: "client",
+		ackTimeout:   fluentdDefaultAckTimeout,
+	}
+}
+
+func (s *fluentdStandIn) received(n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		events := append([]string{}, s.events...)
+		s.mu.Unlock()
+		if len(events) >= n || time.Now().After(deadline) {
+			return events
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFluentdHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverKey string
+		pongKey   string
+		clientKey string
+		want      string
+	}{
+		{"no handshake", "", "", "", ""},
+		{"authenticated", "secret", "secret", "secret", ""},
+		{"rejected", "secret", "secret", "wrong", "authentication failed: shared key mismatch"},
+		{"server with another key", "secret", "other", "secret", "shared key mismatch with server"},
+	}
+	for _, tt := range tests {
+		s := newFluentdStandIn(t, tt.serverKey)
+		s.pongKey = tt.pongKey
+		conn, err := dialFluentd(s.connConfig(tt.clientKey))
+		s.ln.Close()
+
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+				continue
+			}
+			conn.Close()
+			continue
+		}
+		if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFluentdForwarderReplaysSpool(t *testing.T) {
+	spool, err := newFluentdSpool(t.TempDir(), "id", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.write([]*fluentdEntry{{"spooled", time.Now(), map[string]interface{}{"message": "a"}}})
+
+	s := newFluentdStandIn(t, "secret")
+	defer s.ln.Close()
+	f := newFluentdForwarder(s.connConfig("secret"), 0, time.Second, spool)
+	f.post("posted", time.Now(), map[string]interface{}{"message": "b"})
+	f.close()
+
+	events := s.received(2)
+	if strings.Join(events, ",") != "spooled:a,posted:b" {
+		t.Errorf("got %v, want the spooled event before the posted one", events)
+	}
+	if files, _ := spool.claim(); len(files) != 0 {
+		t.Errorf("spool has %v after replay", files)
+	}
+}
+
+func TestFluentdForwarderSpoolsUnsent(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens on the address
+	address := ln.Addr().String()
+	ln.Close()
+
+	spool, err := newFluentdSpool(t.TempDir(), "id", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &fluentdConnConfig{network: "tcp", address: address}
+	f := newFluentdForwarder(config, 0, 0, spool)
+	f.post("tag", time.Now(), map[string]interface{}{"message": "a"})
+	f.close()
+
+	files, err := spool.claim()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("claimed %v, %v, want 1 file", files, err)
+	}
+	entries, err := readSpoolFile(files[0])
+	if err != nil || len(entries) != 1 || entries[0].record["message"] != "a" {
+		t.Errorf("spooled %v, %v", entries, err)
+	}
+}
+
+func TestFluentdForwarderReplaysOnlyAfterConnect(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := newFluentdSpool(dir, "id", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newFluentdStandIn(t, "")
+	defer s.ln.Close()
+	f := newFluentdForwarder(s.connConfig(""), 0, time.Second, spool)
+	f.post("posted", time.Now(), map[string]interface{}{"message": "a"})
+	s.received(1)
+
+	// another run spools an event while this one is connected
+	other, _ := newFluentdSpool(dir, "other", "name")
+	other.write([]*fluentdEntry{{"spooled", time.Now(), map[string]interface{}{"message": "b"}}})
+	f.post("posted", time.Now(), map[string]interface{}{"message": "c"})
+	f.close()
+
+	if events := s.received(2); strings.Join(events, ",") != "posted:a,posted:c" {
+		t.Errorf("got %v, want only the posted events", events)
+	}
+	if files, _ := spool.claim(); len(files) != 1 {
+		t.Errorf("claimed %v, want the file of the other run", files)
+	}
+}
+
+func TestFluentdForwarderSpillsFullBuffer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	spool, err := newFluentdSpool(t.TempDir(), "id", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFluentdForwarder(&fluentdConnConfig{network: "tcp", address: address}, 2, 0, spool)
+	for _, m := range []string{"a", "b", "c", "d"} {
+		f.post("tag", time.Now(), map[string]interface{}{"message": m})
+	}
+	f.close()
+
+	files, err := spool.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, path := range files {
+		entries, err := readSpoolFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			messages = append(messages, e.record["message"].(string))
+		}
+	}
+	if strings.Join(messages, ",") != "a,b,c,d" {
+		t.Errorf("spooled %v, want all the events in order", messages)
+	}
+	if f.dropped != 0 {
+		t.Errorf("dropped %d events", f.dropped)
+	}
+}
@@ -0,0 +1,274 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	fluentdWriteTimeout = 10 * time.Second
+	fluentdMinRetryWait = 500 * time.Millisecond
+	fluentdMaxRetryWait = 30 * time.Second
+)
+
+type fluentdEntry struct {
+	tag    string
+	time   time.Time
+	record map[string]interface{}
+}
+
+// fluentdMessage is an event in Message Mode of the fluentd forward protocol.
+type fluentdMessage struct {
+	_msgpack struct{} `msgpack:",as_array"`
+	Tag      string
+	Time     int64
+	Record   map[string]interface{}
+}
+
+func (e *fluentdEntry) message() *fluentdMessage {
+	return &fluentdMessage{Tag: e.tag, Time: e.time.Unix(), Record: e.record}
+}
+
+func (m *fluentdMessage) entry() *fluentdEntry {
+	return &fluentdEntry{m.Tag, time.Unix(m.Time, 0), m.Record}
+}
+
+// fluentdForwarder sends entries to fluentd in background. Entries are buffered in memory
+// while fluentd is unreachable, and spilled to the spool when the buffer is full or when
+// the forwarder is closed before they are sent.
+type fluentdForwarder struct {
+	connConfig   *fluentdConnConfig
+	bufferLimit  int
+	flushTimeout time.Duration
+	spool        *fluentdSpool
+
+	mu      sync.Mutex
+	queue   []*fluentdEntry
+	dropped int
+	// replay is set when the spool has to be replayed, after a connection has been made or
+	// entries have been spilled to the spool
+	replay bool
+
+	conn    *fluentdConn
+	wake    chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+func newFluentdForwarder(connConfig *fluentdConnConfig, bufferLimit int, flushTimeout time.Duration, spool *fluentdSpool) *fluentdForwarder {
+	f := &fluentdForwarder{
+		connConfig:   connConfig,
+		bufferLimit:  bufferLimit,
+		flushTimeout: flushTimeout,
+		spool:        spool,
+		queue:        make([]*fluentdEntry, 0),
+		wake:         make(chan struct{}, 1),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+func (f *fluentdForwarder) post(tag string, tm time.Time, record map[string]interface{}) {
+	f.mu.Lock()
+	f.queue = append(f.queue, &fluentdEntry{tag, tm, record})
+	var spilled []*fluentdEntry
+	if f.bufferLimit > 0 && len(f.queue) > f.bufferLimit {
+		if f.spool != nil {
+			spilled = f.queue
+			f.queue = make([]*fluentdEntry, 0)
+		} else {
+			f.dropOldest()
+		}
+	}
+	f.mu.Unlock()
+
+	if spilled != nil {
+		f.spill(spilled)
+	}
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// spill moves entries taken from the buffer to the spool. It is called without f.mu held, so
+// that posts are not blocked by the disk. If the spool fails, the entries are returned to the
+// buffer and the oldest entries are dropped.
+func (f *fluentdForwarder) spill(entries []*fluentdEntry) {
+	err := f.spool.write(entries)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.replay = true
+		return
+	}
+	fmt.Fprintf(os.Stderr, "failed to spool fluentd events. %s\n", err)
+	f.queue = append(entries, f.queue...)
+	f.dropOldest()
+}
+
+// dropOldest drops entries over the buffer limit. It must be called with f.mu held.
+func (f *fluentdForwarder) dropOldest() {
+	if over := len(f.queue) - f.bufferLimit; over > 0 {
+		f.dropped += over
+		f.queue = f.queue[over:]
+	}
+}
+
+func (f *fluentdForwarder) run() {
+	defer close(f.done)
+
+	wait := fluentdMinRetryWait
+	for {
+		if err := f.flush(); err != nil {
+			select {
+			case <-time.After(wait):
+			case <-f.closing:
+				return
+			}
+			if wait *= 2; wait > fluentdMaxRetryWait {
+				wait = fluentdMaxRetryWait
+			}
+			continue
+		}
+
+		wait = fluentdMinRetryWait
+		select {
+		case <-f.wake:
+		case <-f.closing:
+			return
+		}
+	}
+}
+
+func (f *fluentdForwarder) connect() error {
+	if f.conn != nil {
+		return nil
+	}
+	conn, err := dialFluentd(f.connConfig)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	// the spool may have been written by other runs while fluentd was unreachable
+	f.mu.Lock()
+	f.replay = true
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fluentdForwarder) disconnect() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+}
+
+// flush replays the spool if needed, and sends buffered entries.
+func (f *fluentdForwarder) flush() error {
+	if err := f.connect(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	replay := f.replay
+	f.replay = false
+	f.mu.Unlock()
+	if replay {
+		if err := f.replaySpool(); err != nil {
+			f.disconnect()
+			return err
+		}
+	}
+
+	for {
+		f.mu.Lock()
+		entries := f.queue
+		f.queue = make([]*fluentdEntry, 0)
+		f.mu.Unlock()
+
+		if len(entries) == 0 {
+			return nil
+		}
+
+		sent, err := f.send(entries)
+		if err != nil {
+			f.mu.Lock()
+			f.queue = append(entries[sent:], f.queue...)
+			f.mu.Unlock()
+			f.disconnect()
+			return err
+		}
+	}
+}
+
+func (f *fluentdForwarder) replaySpool() error {
+	if f.spool == nil {
+		return nil
+	}
+
+	files, err := f.spool.claim()
+	if err != nil {
+		return err
+	}
+	for i, path := range files {
+		entries, err := readSpoolFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "broken fluentd spool file %s. %s\n", path, err)
+		}
+		if _, err := f.send(entries); err != nil {
+			// entries sent before the failure will be sent again
+			for _, p := range files[i:] {
+				f.spool.unclaim(p)
+			}
+			return err
+		}
+		os.Remove(path)
+	}
+	return nil
+}
+
+func (f *fluentdForwarder) send(entries []*fluentdEntry) (int, error) {
+	return f.conn.send(entries, fluentdWriteTimeout)
+}
+
+// close sends remaining entries within flushTimeout and spools the rest.
+func (f *fluentdForwarder) close() {
+	close(f.closing)
+	<-f.done
+
+	deadline := time.Now().Add(f.flushTimeout)
+	for {
+		err := f.flush()
+		if err == nil {
+			break
+		}
+		if time.Now().Add(fluentdMinRetryWait).After(deadline) {
+			fmt.Fprintf(os.Stderr, "failed to send events to fluentd. %s\n", err)
+			break
+		}
+		time.Sleep(fluentdMinRetryWait)
+	}
+	f.disconnect()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.queue) > 0 {
+		if f.spool == nil {
+			f.dropped += len(f.queue)
+		} else if err := f.spool.write(f.queue); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to spool fluentd events. %s\n", err)
+			f.dropped += len(f.queue)
+		}
+		f.queue = nil
+	}
+	if f.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "%d fluentd events have been dropped\n", f.dropped)
+	}
+}
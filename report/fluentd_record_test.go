@@ -0,0 +1,89 @@
+package report
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		style string
+		want  string
+	}{
+		{"commandId", KeyStyleCamel, "commandId"},
+		{"commandId", "", "commandId"},
+		{"commandId", KeyStyleSnake, "command_id"},
+		{"exitCode", KeyStyleSnake, "exit_code"},
+		{"message", KeyStyleSnake, "message"},
+	}
+	for _, tt := range tests {
+		if got := formatKey(tt.key, tt.style); got != tt.want {
+			t.Errorf("formatKey(%q, %q) = %q, want %q", tt.key, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestFluentdRecord(t *testing.T) {
+	exitErr := func(script string) *exec.ExitError {
+		err := exec.Command("/bin/sh", "-c", script).Run()
+		e, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatalf("%s: %v", script, err)
+		}
+		return e
+	}
+	at := time.Unix(1700000000, 0)
+	tests := []struct {
+		name        string
+		keyStyle    string
+		omitMessage bool
+		labels      map[string]string
+		err         *exec.ExitError
+		want        map[string]interface{}
+	}{
+		{"camel", KeyStyleCamel, true, nil, exitErr("exit 3"), map[string]interface{}{
+			"commandId": "id", "commandName": "name", "hostname": "host",
+			"count": 1, "duration": 1.0, "error": "exit status 3", "exitCode": 3,
+		}},
+		{"snake with labels", KeyStyleSnake, true, map[string]string{"env": "prod", "team_name": "data"}, exitErr("exit 3"), map[string]interface{}{
+			"command_id": "id", "command_name": "name", "hostname": "host", "env": "prod", "team_name": "data",
+			"count": 1, "duration": 1.0, "error": "exit status 3", "exit_code": 3,
+		}},
+		{"signal", KeyStyleSnake, true, nil, exitErr("kill -TERM $$"), map[string]interface{}{
+			"command_id": "id", "command_name": "name", "hostname": "host",
+			"count": 1, "duration": 1.0, "error": "signal: terminated", "exit_code": -1, "signal": 15,
+		}},
+	}
+	for _, tt := range tests {
+		f := &fluentdForwarder{}
+		r := &fluentdReporter{
+			commandId:      "id",
+			commandName:    "name",
+			hostname:       "host",
+			tagPrefix:      "command",
+			labels:         tt.labels,
+			keyStyle:       tt.keyStyle,
+			omitMessage:    tt.omitMessage,
+			forwarder:      f,
+			eventFormatter: newEventFormatter("id", "name"),
+		}
+		r.attemptFail(1, tt.err, at, time.Second, nil)
+		if len(f.queue) != 1 {
+			t.Fatalf("%s: got %d records, want 1", tt.name, len(f.queue))
+		}
+		if e := f.queue[0]; e.tag != "command.attempt_fail" || !reflect.DeepEqual(e.record, tt.want) {
+			t.Errorf("%s: got %s %v, want %v", tt.name, e.tag, e.record, tt.want)
+		}
+	}
+
+	// the message is included unless omitted
+	f := &fluentdForwarder{}
+	r := &fluentdReporter{commandId: "id", commandName: "name", tagPrefix: "command", forwarder: f, eventFormatter: newEventFormatter("id", "name")}
+	r.commandStart(at)
+	if _, ok := f.queue[0].record["message"]; !ok {
+		t.Errorf("record %v has no message", f.queue[0].record)
+	}
+}
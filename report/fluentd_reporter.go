@@ -1,16 +1,15 @@
 package report
 
 import (
-	"bytes"
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
-
-	"github.com/t-k/fluent-logger-golang/fluent"
 )
 
 const (
+	commandLockTag         = "command_lock"
 	commandStartTag        = "command_start"
 	commandSucceedTag      = "command_succeed"
 	commandFailTag         = "command_fail"
@@ -18,6 +17,8 @@ const (
 	attemptSucceedTag      = "attempt_succeed"
 	attemptFailTag         = "attempt_fail"
 	attemptTimeoutTag      = "attempt_timeout"
+	attemptOOMTag          = "attempt_oom"
+	attemptCriteriaFailTag = "attempt_criteria_fail"
 	attemptUnknownErrorTag = "attempt_unknown_error"
 	stdoutTag              = "stdout"
 	stderrTag              = "stderr"
@@ -28,105 +29,134 @@ type fluentdReporter struct {
 	commandName string
 	hostname    string
 	tagPrefix   string
-	logger      *fluent.Fluent
-	stdout      chan string
-	stderr      chan string
-
-	// buf stores stringReporter result. This is useful when you want to send notification
-	// with other tool such as hipchat.
-	buf *bytes.Buffer
-	sr  *stringReporter
+	labels      map[string]string
+	keyStyle    string
+	omitMessage bool
+	forwarder   *fluentdForwarder
+	stdoutCount int
+	stderrCount int
+
+	// eventFormatter formats messages of events. This is useful when you want to send
+	// notification with other tool such as hipchat.
+	*eventFormatter
+	reporterDefaults
 }
 
-func newFluentdReporter(commandId string, commandName string, host string, port int, tagPrefix string) (*fluentdReporter, error) {
-	logger, err := fluent.New(fluent.Config{
-		FluentHost: host,
-		FluentPort: port,
-	})
+// newFluentdReporter does not connect to fluentd. Events are buffered until the connection
+// is established, so that the reporter works even if fluentd is down.
+func newFluentdReporter(commandId string, commandName string, config *FluentdConfig, labels map[string]string) (*fluentdReporter, error) {
+	if err := validateKeyStyle(config.KeyStyle); err != nil {
+		return nil, err
+	}
 
+	hostname, err := os.Hostname()
 	if err != nil {
 		return nil, err
 	}
 
-	hostname, err := os.Hostname()
+	connConfig, err := newFluentdConnConfig(config, hostname)
 	if err != nil {
 		return nil, err
 	}
 
-	buf := new(bytes.Buffer)
-	sr := &stringReporter{
-		commandId:   commandId,
-		commandName: commandName,
-		fh:          buf,
+	var spool *fluentdSpool
+	if config.SpoolDirectory != "" {
+		spool, err = newFluentdSpool(config.SpoolDirectory, commandId, commandName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to initialize fluentd spool. events are not spooled. %s\n", err)
+		}
 	}
 
 	return &fluentdReporter{
-		commandId:   commandId,
-		commandName: commandName,
-		hostname:    hostname,
-		tagPrefix:   tagPrefix,
-		logger:      logger,
-		buf:         buf,
-		sr:          sr,
+		commandId:      commandId,
+		commandName:    commandName,
+		hostname:       hostname,
+		tagPrefix:      config.TagPrefix,
+		labels:         labels,
+		keyStyle:       config.KeyStyle,
+		omitMessage:    config.OmitMessage,
+		forwarder:      newFluentdForwarder(connConfig, config.BufferLimit, config.FlushTimeout, spool),
+		eventFormatter: newEventFormatter(commandId, commandName),
 	}, nil
 }
 
+// createRecord builds a record from camelCase keys. Keys are converted to the configured
+// style, while labels are added as they are.
 func (r *fluentdReporter) createRecord(rest map[string]interface{}) map[string]interface{} {
-	ret := map[string]interface{}{
+	ret := map[string]interface{}{}
+	for k, v := range r.labels {
+		ret[k] = v
+	}
+
+	common := map[string]interface{}{
 		"commandId":   r.commandId,
 		"commandName": r.commandName,
 		"hostname":    r.hostname,
 	}
-
-	for v, k := range rest {
-		ret[v] = k
+	for _, m := range []map[string]interface{}{common, rest} {
+		for k, v := range m {
+			if k == "message" && r.omitMessage {
+				continue
+			}
+			ret[formatKey(k, r.keyStyle)] = v
+		}
 	}
 
 	return ret
 }
 
+func (r *fluentdReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.sr.commandLock(decision, holderPid, at, waited)
+	message := r.message()
+
+	record := r.createRecord(map[string]interface{}{
+		"decision":  decision,
+		"holderPid": holderPid,
+		"waited":    waited.Seconds(),
+		"message":   message,
+	})
+	tag := makeTag(r.tagPrefix, commandLockTag)
+	r.forwarder.post(tag, at, record)
+}
+
 func (r *fluentdReporter) commandStart(startAt time.Time) {
 	r.sr.commandStart(startAt)
-	message := r.buf.String()
-	r.buf.Reset()
+	message := r.message()
 
 	record := r.createRecord(map[string]interface{}{
 		"message": message,
 	})
 	tag := makeTag(r.tagPrefix, commandStartTag)
-	r.logger.PostWithTime(tag, startAt, record)
+	r.forwarder.post(tag, startAt, record)
 }
 
 func (r *fluentdReporter) commandSucceed(endAt time.Time, duration time.Duration) {
 	r.sr.commandSucceed(endAt, duration)
-	message := r.buf.String()
-	r.buf.Reset()
+	message := r.message()
 
 	record := r.createRecord(map[string]interface{}{
 		"duration": duration.Seconds(),
 		"message":  message,
 	})
 	tag := makeTag(r.tagPrefix, commandSucceedTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
 }
 
 func (r *fluentdReporter) commandFail(endAt time.Time, duration time.Duration) {
 	r.sr.commandFail(endAt, duration)
-	message := r.buf.String()
-	r.buf.Reset()
+	message := r.message()
 
 	record := r.createRecord(map[string]interface{}{
 		"duration": duration.Seconds(),
 		"message":  message,
 	})
 	tag := makeTag(r.tagPrefix, commandFailTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
 }
 
 func (r *fluentdReporter) attemptStart(count int, pid int, startAt time.Time) {
 	r.sr.attemptStart(count, pid, startAt)
-	message := r.buf.String()
-	r.buf.Reset()
+	message := r.message()
 
 	record := r.createRecord(map[string]interface{}{
 		"count":   count,
@@ -134,112 +164,140 @@ func (r *fluentdReporter) attemptStart(count int, pid int, startAt time.Time) {
 		"message": message,
 	})
 	tag := makeTag(r.tagPrefix, attemptStartTag)
-	r.logger.PostWithTime(tag, startAt, record)
+	r.forwarder.post(tag, startAt, record)
 }
 
-func (r *fluentdReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration) {
-	r.sr.attemptSucceed(count, endAt, duration)
-	message := r.buf.String()
-	r.buf.Reset()
+func (r *fluentdReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptSucceed(count, endAt, duration, usage)
+	message := r.message()
 
-	record := r.createRecord(map[string]interface{}{
+	record := r.createAttemptEndRecord(map[string]interface{}{
 		"count":    count,
 		"duration": duration.Seconds(),
 		"message":  message,
-	})
+	}, usage)
 	tag := makeTag(r.tagPrefix, attemptSucceedTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
 }
 
-func (r *fluentdReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration) {
-	r.sr.attemptFail(count, err, endAt, duration)
-	message := r.buf.String()
-	r.buf.Reset()
+func (r *fluentdReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptFail(count, err, endAt, duration, usage)
+	message := r.message()
 
-	record := r.createRecord(map[string]interface{}{
+	fields := map[string]interface{}{
 		"count":    count,
 		"duration": duration.Seconds(),
-		"error":    err,
+		"error":    err.Error(),
 		"message":  message,
-	})
+	}
+	code, signal, signaled := exitStatus(err)
+	fields["exitCode"] = code
+	if signaled {
+		fields["signal"] = signal
+	}
+	record := r.createAttemptEndRecord(fields, usage)
 	tag := makeTag(r.tagPrefix, attemptFailTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
 }
 
-func (r *fluentdReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration) {
-	r.sr.attemptTimeout(count, endAt, duration)
-	message := r.buf.String()
-	r.buf.Reset()
+func (r *fluentdReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptTimeout(count, endAt, duration, usage)
+	message := r.message()
 
-	record := r.createRecord(map[string]interface{}{
+	record := r.createAttemptEndRecord(map[string]interface{}{
 		"count":    count,
 		"duration": duration.Seconds(),
 		"message":  message,
-	})
+	}, usage)
 	tag := makeTag(r.tagPrefix, attemptTimeoutTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
+}
+
+func (r *fluentdReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptOOM(count, err, endAt, duration, usage)
+	message := r.message()
+
+	fields := map[string]interface{}{
+		"count":    count,
+		"duration": duration.Seconds(),
+		"error":    err.Error(),
+		"message":  message,
+	}
+	code, signal, signaled := exitStatus(err)
+	fields["exitCode"] = code
+	if signaled {
+		fields["signal"] = signal
+	}
+	record := r.createAttemptEndRecord(fields, usage)
+	tag := makeTag(r.tagPrefix, attemptOOMTag)
+	r.forwarder.post(tag, endAt, record)
+}
+
+func (r *fluentdReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptCriteriaFail(count, criterion, endAt, duration, usage)
+	message := r.message()
+
+	record := r.createAttemptEndRecord(map[string]interface{}{
+		"count":     count,
+		"duration":  duration.Seconds(),
+		"criterion": criterion,
+		"message":   message,
+	}, usage)
+	tag := makeTag(r.tagPrefix, attemptCriteriaFailTag)
+	r.forwarder.post(tag, endAt, record)
 }
 
 func (r *fluentdReporter) attemptUnknownError(count int, err error, endAt time.Time) {
 	r.sr.attemptUnknownError(count, err, endAt)
-	message := r.buf.String()
-	r.buf.Reset()
+	message := r.message()
 
 	record := r.createRecord(map[string]interface{}{
 		"count":   count,
-		"error":   err,
+		"error":   err.Error(),
 		"message": message,
 	})
 	tag := makeTag(r.tagPrefix, attemptUnknownErrorTag)
-	r.logger.PostWithTime(tag, endAt, record)
+	r.forwarder.post(tag, endAt, record)
 }
 
 func (r *fluentdReporter) startStdoutLogger(count int) {
-	r.stdout = make(chan string)
-	go func() {
-		for log := range r.stdout {
-			record := r.createRecord(map[string]interface{}{
-				"count": count,
-				"log":   log,
-			})
-			tag := makeTag(r.tagPrefix, stdoutTag)
-			r.logger.Post(tag, record)
-		}
-	}()
-}
-
-func (r *fluentdReporter) finishStdoutLogger() {
-	close(r.stdout)
+	r.stdoutCount = count
 }
 
 func (r *fluentdReporter) stdoutLog(log string) {
-	r.stdout <- log
+	record := r.createRecord(map[string]interface{}{
+		"count": r.stdoutCount,
+		"log":   log,
+	})
+	tag := makeTag(r.tagPrefix, stdoutTag)
+	r.forwarder.post(tag, time.Now(), record)
 }
 
 func (r *fluentdReporter) startStderrLogger(count int) {
-	r.stderr = make(chan string)
-	go func() {
-		for log := range r.stderr {
-			record := r.createRecord(map[string]interface{}{
-				"count": count,
-				"log":   log,
-			})
-			tag := makeTag(r.tagPrefix, stderrTag)
-			r.logger.Post(tag, record)
-		}
-	}()
-}
-
-func (r *fluentdReporter) finishStderrLogger() {
-	close(r.stderr)
+	r.stderrCount = count
 }
 
 func (r *fluentdReporter) stderrLog(log string) {
-	r.stderr <- log
+	record := r.createRecord(map[string]interface{}{
+		"count": r.stderrCount,
+		"log":   log,
+	})
+	tag := makeTag(r.tagPrefix, stderrTag)
+	r.forwarder.post(tag, time.Now(), record)
 }
 
 func (r *fluentdReporter) close() {
-	r.logger.Close()
+	r.forwarder.close()
+}
+
+// createAttemptEndRecord creates a record of the end of an attempt with the resource usage.
+func (r *fluentdReporter) createAttemptEndRecord(fields map[string]interface{}, usage *ResourceUsage) map[string]interface{} {
+	if usage != nil {
+		for k, v := range usage.fields() {
+			fields[k] = v
+		}
+	}
+	return r.createRecord(fields)
 }
 
 func makeTag(s ...string) string {
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	spoolExt       = ".msgpack"
+	spoolClaimExt  = ".replaying"
+	spoolStaleTime = 10 * time.Minute
+)
+
+// fluentdSpool stores events which could not be forwarded in files of a local directory.
+// The directory can be shared by runs of any command, and each file is replayed by the
+// first run which connects to fluentd.
+type fluentdSpool struct {
+	directory string
+	commandId string
+	prefix    string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func newFluentdSpool(directory string, commandId string, commandName string) (*fluentdSpool, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	return &fluentdSpool{
+		directory: directory,
+		commandId: commandId,
+		prefix:    commandId + "-" + strings.Replace(commandName, string(filepath.Separator), "_", -1),
+	}, nil
+}
+
+// write stores entries in a new spool file. File names start with the command id, so
+// sorting them by name replays events in the order they were spooled.
+func (s *fluentdSpool) write(entries []*fluentdEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.seq++
+	name := fmt.Sprintf("%s-%06d%s", s.prefix, s.seq, spoolExt)
+	s.mu.Unlock()
+
+	path := filepath.Join(s.directory, name)
+	tmp := path + ".tmp"
+	fh, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(fh)
+	enc := msgpack.NewEncoder(w)
+	for _, e := range entries {
+		if err = enc.Encode(e.message()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// claim returns spool files which this process is responsible to replay. A file is claimed
+// by renaming it to a name of this run, so that concurrent runs do not replay the same events.
+// Claims left by a crashed process are taken over after spoolStaleTime.
+func (s *fluentdSpool) claim() ([]string, error) {
+	files, err := ioutil.ReadDir(s.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	ret := make([]string, 0)
+	now := time.Now()
+	for _, f := range files {
+		path := filepath.Join(s.directory, f.Name())
+		switch {
+		case strings.HasSuffix(f.Name(), spoolExt):
+		case strings.HasSuffix(f.Name(), spoolClaimExt) && now.Sub(f.ModTime()) > spoolStaleTime:
+		default:
+			continue
+		}
+		claimed := spoolFileOf(path) + "." + s.commandId + spoolClaimExt
+		if err := os.Rename(path, claimed); err != nil {
+			// another process has claimed it
+			continue
+		}
+		os.Chtimes(claimed, now, now)
+		ret = append(ret, claimed)
+	}
+	return ret, nil
+}
+
+// unclaim returns a claimed file to the spool so that it is replayed later.
+func (s *fluentdSpool) unclaim(path string) {
+	os.Rename(path, spoolFileOf(path))
+}
+
+// spoolFileOf returns the name of a spool file before it has been claimed.
+func spoolFileOf(path string) string {
+	return path[:strings.LastIndex(path, spoolExt)+len(spoolExt)]
+}
+
+func readSpoolFile(path string) ([]*fluentdEntry, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	ret := make([]*fluentdEntry, 0)
+	dec := msgpack.NewDecoder(bufio.NewReader(fh))
+	for {
+		msg := &fluentdMessage{}
+		if err := dec.Decode(msg); err != nil {
+			if err == io.EOF {
+				return ret, nil
+			}
+			return ret, err
+		}
+		ret = append(ret, msg.entry())
+	}
+}
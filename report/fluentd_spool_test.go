@@ -0,0 +1,81 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFluentdSpool(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newFluentdSpool(dir, "id", "dir/name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1700000000, 0)
+	first := []*fluentdEntry{{"tag.a", at, map[string]interface{}{"message": "a"}}}
+	second := []*fluentdEntry{
+		{"tag.b", at, map[string]interface{}{"message": "b"}},
+		{"tag.c", at.Add(time.Second), map[string]interface{}{"message": "c"}},
+	}
+	for _, entries := range [][]*fluentdEntry{first, nil, second} {
+		if err := s.write(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := s.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("claimed %v, want 2 files", files)
+	}
+	for i, want := range [][]*fluentdEntry{first, second} {
+		if !strings.HasPrefix(filepath.Base(files[i]), "id-dir_name-") || !strings.HasSuffix(files[i], spoolExt+".id"+spoolClaimExt) {
+			t.Errorf("unexpected name of a claimed file: %s", files[i])
+		}
+		entries, err := readSpoolFile(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(entries, want) {
+			t.Errorf("file %d has %v, want %v", i, entries, want)
+		}
+	}
+
+	// a claimed file is not claimed again until it is stale or unclaimed
+	other, _ := newFluentdSpool(dir, "other", "name")
+	if files, _ := other.claim(); len(files) != 0 {
+		t.Errorf("claimed %v, which have been claimed", files)
+	}
+	s.unclaim(files[0])
+	old := time.Now().Add(-2 * spoolStaleTime)
+	os.Chtimes(files[1], old, old)
+	reclaimed, err := other.claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]string, len(files))
+	for i, f := range files {
+		want[i] = strings.Replace(f, ".id"+spoolClaimExt, ".other"+spoolClaimExt, 1)
+	}
+	if !reflect.DeepEqual(reclaimed, want) {
+		t.Errorf("reclaimed %v, want %v", reclaimed, want)
+	}
+
+	// a stale claim is taken over by only one of the runs
+	for _, f := range reclaimed {
+		os.Chtimes(f, old, old)
+	}
+	third, _ := newFluentdSpool(dir, "third", "name")
+	if files, _ := third.claim(); len(files) != 2 {
+		t.Errorf("claimed %v, want 2 stale files", files)
+	}
+	if files, _ := other.claim(); len(files) != 0 {
+		t.Errorf("claimed %v, which have been taken over", files)
+	}
+}
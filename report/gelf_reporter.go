@@ -0,0 +1,261 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// syslog severities used as GELF levels
+const (
+	gelfLevelError = 3
+	gelfLevelInfo  = 6
+)
+
+type gelfReporter struct {
+	commandId   string
+	commandName string
+	hostname    string
+	labels      map[string]string
+	writer      *gelfWriter
+
+	stdoutAttempt int
+	stderrAttempt int
+	stdoutLines   lineBuffer
+	stderrLines   lineBuffer
+
+	// pids of attempts, which are read by output loggers
+	pidMu sync.Mutex
+	pids  map[int]int
+
+	*eventFormatter
+}
+
+func newGelfReporter(commandId string, commandName string, config *GelfConfig, labels map[string]string) (*gelfReporter, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := newGelfWriter(config.Protocol, config.Address, config.ChunkSize, config.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gelfReporter{
+		commandId:      commandId,
+		commandName:    commandName,
+		hostname:       hostname,
+		labels:         labels,
+		writer:         writer,
+		pids:           make(map[int]int),
+		eventFormatter: newEventFormatter(commandId, commandName),
+	}, nil
+}
+
+func (r *gelfReporter) send(tm time.Time, level int, shortMessage string, fields map[string]interface{}) {
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          r.hostname,
+		"short_message": shortMessage,
+		"timestamp":     float64(tm.UnixNano()) / float64(time.Second),
+		"level":         level,
+	}
+	for k, v := range r.labels {
+		msg["_"+k] = v
+	}
+	msg["_command_id"] = r.commandId
+	msg["_command_name"] = r.commandName
+	for k, v := range fields {
+		msg["_"+k] = v
+	}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode a gelf message. %s\n", err)
+		return
+	}
+	r.writer.send(b)
+}
+
+// sendEvent sends the message of the event written with sr.
+func (r *gelfReporter) sendEvent(event string, tm time.Time, level int, fields map[string]interface{}) {
+	fields["event"] = event
+	r.send(tm, level, r.trimmedMessage(), fields)
+}
+
+func (r *gelfReporter) sendLine(stream string, count int, line string) {
+	// GELF does not allow an empty short_message
+	if line == "" {
+		return
+	}
+	level := gelfLevelInfo
+	if stream == stderrTag {
+		level = gelfLevelError
+	}
+	fields := map[string]interface{}{
+		"stream":  stream,
+		"attempt": count,
+	}
+	r.pidMu.Lock()
+	if pid, ok := r.pids[count]; ok {
+		fields["pid"] = pid
+	}
+	r.pidMu.Unlock()
+	r.send(time.Now(), level, line, fields)
+}
+
+func (r *gelfReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.sr.commandLock(decision, holderPid, at, waited)
+	level := gelfLevelInfo
+	if decision == LockWaitTimeout {
+		level = gelfLevelError
+	}
+	r.sendEvent(commandLockTag, at, level, map[string]interface{}{
+		"lock_decision":   decision,
+		"lock_holder_pid": holderPid,
+		"lock_waited":     waited.Seconds(),
+	})
+}
+
+func (r *gelfReporter) commandStart(startAt time.Time) {
+	r.sr.commandStart(startAt)
+	r.sendEvent(commandStartTag, startAt, gelfLevelInfo, map[string]interface{}{})
+}
+
+func (r *gelfReporter) commandSucceed(endAt time.Time, duration time.Duration) {
+	r.sr.commandSucceed(endAt, duration)
+	r.sendEvent(commandSucceedTag, endAt, gelfLevelInfo, map[string]interface{}{
+		"duration": duration.Seconds(),
+	})
+}
+
+func (r *gelfReporter) commandFail(endAt time.Time, duration time.Duration) {
+	r.sr.commandFail(endAt, duration)
+	r.sendEvent(commandFailTag, endAt, gelfLevelError, map[string]interface{}{
+		"duration": duration.Seconds(),
+	})
+}
+
+func (r *gelfReporter) attemptStart(count int, pid int, startAt time.Time) {
+	r.pidMu.Lock()
+	r.pids[count] = pid
+	r.pidMu.Unlock()
+
+	r.sr.attemptStart(count, pid, startAt)
+	r.sendEvent(attemptStartTag, startAt, gelfLevelInfo, map[string]interface{}{
+		"attempt": count,
+		"pid":     pid,
+	})
+}
+
+func (r *gelfReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptSucceed(count, endAt, duration, usage)
+	r.sendEvent(attemptSucceedTag, endAt, gelfLevelInfo, withGelfUsage(map[string]interface{}{
+		"attempt":   count,
+		"duration":  duration.Seconds(),
+		"exit_code": 0,
+	}, usage))
+}
+
+func (r *gelfReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptFail(count, err, endAt, duration, usage)
+	code, signal, signaled := exitStatus(err)
+	fields := map[string]interface{}{
+		"attempt":   count,
+		"duration":  duration.Seconds(),
+		"exit_code": code,
+	}
+	if signaled {
+		fields["signal"] = signal
+	}
+	r.sendEvent(attemptFailTag, endAt, gelfLevelError, withGelfUsage(fields, usage))
+}
+
+func (r *gelfReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptTimeout(count, endAt, duration, usage)
+	r.sendEvent(attemptTimeoutTag, endAt, gelfLevelError, withGelfUsage(map[string]interface{}{
+		"attempt":  count,
+		"duration": duration.Seconds(),
+	}, usage))
+}
+
+func (r *gelfReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptOOM(count, err, endAt, duration, usage)
+	code, signal, signaled := exitStatus(err)
+	fields := map[string]interface{}{
+		"attempt":   count,
+		"duration":  duration.Seconds(),
+		"exit_code": code,
+	}
+	if signaled {
+		fields["signal"] = signal
+	}
+	r.sendEvent(attemptOOMTag, endAt, gelfLevelError, withGelfUsage(fields, usage))
+}
+
+func (r *gelfReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptCriteriaFail(count, criterion, endAt, duration, usage)
+	r.sendEvent(attemptCriteriaFailTag, endAt, gelfLevelError, withGelfUsage(map[string]interface{}{
+		"attempt":   count,
+		"duration":  duration.Seconds(),
+		"criterion": criterion,
+	}, usage))
+}
+
+func (r *gelfReporter) attemptUnknownError(count int, err error, endAt time.Time) {
+	r.sr.attemptUnknownError(count, err, endAt)
+	r.sendEvent(attemptUnknownErrorTag, endAt, gelfLevelError, map[string]interface{}{
+		"attempt": count,
+		"error":   err.Error(),
+	})
+}
+
+func (r *gelfReporter) startStdoutLogger(count int) {
+	r.stdoutAttempt = count
+}
+
+func (r *gelfReporter) finishStdoutLogger() {
+	if line, ok := r.stdoutLines.flush(); ok {
+		r.sendLine(stdoutTag, r.stdoutAttempt, line)
+	}
+}
+
+func (r *gelfReporter) stdoutLog(log string) {
+	for _, line := range r.stdoutLines.lines(log) {
+		r.sendLine(stdoutTag, r.stdoutAttempt, line)
+	}
+}
+
+func (r *gelfReporter) startStderrLogger(count int) {
+	r.stderrAttempt = count
+}
+
+func (r *gelfReporter) finishStderrLogger() {
+	if line, ok := r.stderrLines.flush(); ok {
+		r.sendLine(stderrTag, r.stderrAttempt, line)
+	}
+}
+
+func (r *gelfReporter) stderrLog(log string) {
+	for _, line := range r.stderrLines.lines(log) {
+		r.sendLine(stderrTag, r.stderrAttempt, line)
+	}
+}
+
+func (r *gelfReporter) close() {
+	r.writer.close()
+}
+
+// withGelfUsage adds the resource usage to fields of the end of an attempt.
+func withGelfUsage(fields map[string]interface{}, usage *ResourceUsage) map[string]interface{} {
+	if usage != nil {
+		for k, v := range usage.fields() {
+			fields[formatKey(k, KeyStyleSnake)] = v
+		}
+	}
+	return fields
+}
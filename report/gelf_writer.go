@@ -0,0 +1,201 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	gelfDefaultChunkSize = 1420
+	gelfChunkHeaderSize  = 12
+	gelfMaxChunks        = 128
+	gelfDialTimeout      = 3 * time.Second
+	gelfWriteTimeout     = 10 * time.Second
+	gelfCloseTimeout     = 5 * time.Second
+
+	// zlib is available only for GELF UDP, in addition to the file reporter compressions
+	CompressionZlib = "zlib"
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfWriter sends GELF messages in background. UDP messages larger than chunkSize are
+// split into chunks, and TCP messages are delimited by a null byte.
+type gelfWriter struct {
+	network     string
+	address     string
+	chunkSize   int
+	compression string
+
+	conn     net.Conn
+	messages chan []byte
+	// dropped counts messages which have failed to be sent or have not fit in the buffer
+	dropped int64
+	done    chan struct{}
+}
+
+func newGelfWriter(network string, address string, chunkSize int, compression string) (*gelfWriter, error) {
+	switch network {
+	case "udp":
+		if compression != CompressionNone && compression != CompressionGzip && compression != CompressionZlib {
+			return nil, fmt.Errorf("unknown gelf compression: %s", compression)
+		}
+	case "tcp":
+		if compression != CompressionNone {
+			return nil, fmt.Errorf("gelf tcp does not support compression")
+		}
+	default:
+		return nil, fmt.Errorf("unknown gelf protocol: %s", network)
+	}
+	if chunkSize <= gelfChunkHeaderSize {
+		chunkSize = gelfDefaultChunkSize
+	}
+
+	w := &gelfWriter{
+		network:     network,
+		address:     address,
+		chunkSize:   chunkSize,
+		compression: compression,
+		messages:    make(chan []byte, 1024),
+		done:        make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// send queues the message, or drops it if the buffer is full, so that the command output is
+// not blocked when the server cannot keep up.
+func (w *gelfWriter) send(message []byte) {
+	select {
+	case w.messages <- message:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+	}
+}
+
+func (w *gelfWriter) run() {
+	defer close(w.done)
+
+	failed := false
+	for message := range w.messages {
+		err := w.write(message)
+		if err != nil && w.network == "tcp" {
+			// the connection may have been closed by the server, so retry once with a new one
+			w.disconnect()
+			err = w.write(message)
+		}
+		if err != nil {
+			if !failed {
+				fmt.Fprintf(os.Stderr, "failed to send a gelf message. %s\n", err)
+				failed = true
+			}
+			atomic.AddInt64(&w.dropped, 1)
+			w.disconnect()
+		}
+	}
+	w.disconnect()
+}
+
+// close waits for remaining messages to be sent within gelfCloseTimeout.
+func (w *gelfWriter) close() {
+	close(w.messages)
+	select {
+	case <-w.done:
+		if dropped := atomic.LoadInt64(&w.dropped); dropped > 0 {
+			fmt.Fprintf(os.Stderr, "%d gelf messages have been dropped\n", dropped)
+		}
+	case <-time.After(gelfCloseTimeout):
+		fmt.Fprintf(os.Stderr, "failed to send remaining gelf messages in %s\n", gelfCloseTimeout)
+	}
+}
+
+func (w *gelfWriter) disconnect() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+func (w *gelfWriter) write(message []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, gelfDialTimeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(gelfWriteTimeout))
+
+	if w.network == "tcp" {
+		_, err := w.conn.Write(append(message, 0))
+		return err
+	}
+
+	message, err := w.compress(message)
+	if err != nil {
+		return err
+	}
+	if len(message) <= w.chunkSize {
+		_, err := w.conn.Write(message)
+		return err
+	}
+	return w.writeChunks(message)
+}
+
+func (w *gelfWriter) compress(message []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch w.compression {
+	case CompressionGzip:
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(message); err == nil {
+			err = zw.Close()
+		}
+	case CompressionZlib:
+		zw := zlib.NewWriter(&buf)
+		if _, err = zw.Write(message); err == nil {
+			err = zw.Close()
+		}
+	default:
+		return message, nil
+	}
+	return buf.Bytes(), err
+}
+
+// writeChunks sends a message as GELF chunks, each of which has the magic bytes, a message
+// id, a sequence number and the number of chunks before the data.
+func (w *gelfWriter) writeChunks(message []byte) error {
+	dataSize := w.chunkSize - gelfChunkHeaderSize
+	count := (len(message) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("message of %d bytes needs more than %d chunks", len(message), gelfMaxChunks)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, 0, w.chunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(message) {
+			end = len(message)
+		}
+		chunk = append(chunk[:0], gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, message[i*dataSize:end]...)
+		if _, err := w.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
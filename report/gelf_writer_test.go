@@ -0,0 +1,207 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// readGelfUDP reads datagrams until a message is complete, and returns it with the number
+// of datagrams.
+func readGelfUDP(t *testing.T, conn net.PacketConn) ([]byte, int) {
+	buf := make([]byte, 65536)
+	var chunks [][]byte
+	var id []byte
+	for n := 0; ; n++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		size, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := append([]byte{}, buf[:size]...)
+		if !bytes.HasPrefix(p, gelfChunkMagic) {
+			return p, 1
+		}
+		seq, count := int(p[10]), int(p[11])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+			id = p[2:10]
+		}
+		if !bytes.Equal(p[2:10], id) {
+			t.Fatalf("chunk %d has a different message id", seq)
+		}
+		chunks[seq] = p
+		if n+1 == count {
+			var message []byte
+			for _, c := range chunks {
+				message = append(message, c[gelfChunkHeaderSize:]...)
+			}
+			return message, count
+		}
+	}
+}
+
+func decompressGelf(t *testing.T, compression string, message []byte) []byte {
+	var r io.Reader
+	var err error
+	switch compression {
+	case CompressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(message))
+	case CompressionZlib:
+		r, err = zlib.NewReader(bytes.NewReader(message))
+	default:
+		return message
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGelfWriterUDP(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	tests := []struct {
+		name        string
+		compression string
+		chunkSize   int
+		message     []byte
+		datagrams   int
+	}{
+		{"small", CompressionNone, 100, []byte(`{"short_message":"hello"}`), 1},
+		{"exact chunk size", CompressionNone, 100, bytes.Repeat([]byte("a"), 100), 1},
+		{"chunked", CompressionNone, 100, bytes.Repeat([]byte("a"), 250), 3},
+		{"gzip", CompressionGzip, 100, bytes.Repeat([]byte("a"), 1000), 1},
+		{"zlib chunked", CompressionZlib, 100, random, 0},
+		{"gzip chunked", CompressionGzip, 1000, random, 0},
+	}
+	for _, tt := range tests {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := newGelfWriter("udp", conn.LocalAddr().String(), tt.chunkSize, tt.compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.send(tt.message)
+		message, datagrams := readGelfUDP(t, conn)
+		w.close()
+		conn.Close()
+
+		if got := decompressGelf(t, tt.compression, message); !bytes.Equal(got, tt.message) {
+			t.Errorf("%s: got a message of %d bytes, want %d bytes", tt.name, len(got), len(tt.message))
+		}
+		if tt.datagrams > 0 && datagrams != tt.datagrams {
+			t.Errorf("%s: got %d datagrams, want %d", tt.name, datagrams, tt.datagrams)
+		}
+		if tt.datagrams == 0 && datagrams < 2 {
+			t.Errorf("%s: got %d datagrams, want chunks", tt.name, datagrams)
+		}
+	}
+}
+
+func TestGelfWriterTooManyChunks(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := newGelfWriter("udp", conn.LocalAddr().String(), gelfChunkHeaderSize+1, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.send(make([]byte, gelfMaxChunks+1))
+	w.close()
+	if w.dropped != 1 {
+		t.Errorf("got %d dropped messages, want 1", w.dropped)
+	}
+}
+
+func TestGelfWriterFullBuffer(t *testing.T) {
+	// no goroutine receives messages, as if the server could not keep up
+	w := &gelfWriter{messages: make(chan []byte, 2)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			w.send([]byte("message"))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send has blocked on the full buffer")
+	}
+	if w.dropped != 3 {
+		t.Errorf("got %d dropped messages, want 3", w.dropped)
+	}
+}
+
+func TestGelfWriterTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var messages []string
+		r := bufio.NewReader(conn)
+		for {
+			m, err := r.ReadString(0)
+			if err != nil {
+				break
+			}
+			messages = append(messages, m)
+		}
+		received <- messages
+	}()
+
+	w, err := newGelfWriter("tcp", ln.Addr().String(), 0, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.send([]byte("a"))
+	w.send([]byte("b"))
+	w.close()
+
+	messages := <-received
+	if len(messages) != 2 || messages[0] != "a\x00" || messages[1] != "b\x00" {
+		t.Errorf("got %q", messages)
+	}
+}
+
+func TestNewGelfWriterErrors(t *testing.T) {
+	tests := []struct {
+		network     string
+		compression string
+		want        string
+	}{
+		{"udp", CompressionZstd, "unknown gelf compression: zstd"},
+		{"tcp", CompressionGzip, "gelf tcp does not support compression"},
+		{"http", CompressionNone, "unknown gelf protocol: http"},
+	}
+	for _, tt := range tests {
+		_, err := newGelfWriter(tt.network, "127.0.0.1:0", 0, tt.compression)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("newGelfWriter(%s, %s) = %v, want %s", tt.network, tt.compression, err, tt.want)
+		}
+	}
+}
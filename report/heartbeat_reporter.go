@@ -0,0 +1,266 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	heartbeatDefaultTimeout     = 10 * time.Second
+	heartbeatDefaultExcerptSize = 10 * 1024
+	heartbeatMinRetryWait       = time.Second
+	heartbeatMaxRetryWait       = 30 * time.Second
+	// heartbeatCloseTimeout bounds the wait for remaining pings at the end of the command
+	heartbeatCloseTimeout = time.Minute
+)
+
+type heartbeatPing struct {
+	url  string
+	body string
+}
+
+// heartbeatReporter pings a monitoring service such as Healthchecks when the command
+// starts, succeeds and fails. Pings are sent in order in background so that a slow monitor
+// does not delay the command.
+type heartbeatReporter struct {
+	startURL    string
+	successURL  string
+	failURL     string
+	retries     int
+	excerptSize int
+	httpClient  *http.Client
+
+	pings chan *heartbeatPing
+	done  chan struct{}
+	// abort is closed to stop retries when close has given up waiting
+	abort chan struct{}
+
+	// result of the last attempt
+	lastResult string
+
+	stdoutCount int
+	stderrCount int
+
+	// excerptMu guards the output excerpt written by both output loggers
+	excerptMu      sync.Mutex
+	excerpt        []byte
+	excerptAttempt int
+	excerptDropped bool
+
+	*eventFormatter
+	reporterDefaults
+}
+
+func newHeartbeatReporter(commandId string, commandName string, config *HeartbeatConfig) (*heartbeatReporter, error) {
+	if config.StartURL == "" && config.SuccessURL == "" && config.FailURL == "" {
+		return nil, fmt.Errorf("at least one heartbeat url must be specified")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = heartbeatDefaultTimeout
+	}
+	excerptSize := config.ExcerptSize
+	if excerptSize < 0 {
+		excerptSize = 0
+	}
+
+	r := &heartbeatReporter{
+		startURL:       config.StartURL,
+		successURL:     config.SuccessURL,
+		failURL:        config.FailURL,
+		retries:        config.Retries,
+		excerptSize:    excerptSize,
+		httpClient:     &http.Client{Timeout: timeout},
+		pings:          make(chan *heartbeatPing, 4),
+		done:           make(chan struct{}),
+		abort:          make(chan struct{}),
+		eventFormatter: newEventFormatter(commandId, commandName),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *heartbeatReporter) run() {
+	defer close(r.done)
+	for p := range r.pings {
+		r.send(p)
+	}
+}
+
+// send posts a ping, and retries on network errors, 429 and 5xx responses.
+func (r *heartbeatReporter) send(p *heartbeatPing) {
+	wait := heartbeatMinRetryWait
+	for i := 0; ; i++ {
+		retry, err := r.post(p)
+		if err == nil {
+			return
+		}
+		if !retry || i >= r.retries {
+			fmt.Fprintf(os.Stderr, "failed to ping %s. %s\n", p.url, err)
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-r.abort:
+			fmt.Fprintf(os.Stderr, "failed to ping %s. %s\n", p.url, err)
+			return
+		}
+		if wait *= 2; wait > heartbeatMaxRetryWait {
+			wait = heartbeatMaxRetryWait
+		}
+	}
+}
+
+func (r *heartbeatReporter) post(p *heartbeatPing) (bool, error) {
+	res, err := r.httpClient.Post(p.url, "text/plain; charset=utf-8", strings.NewReader(p.body))
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s", res.Status)
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5, err
+}
+
+func (r *heartbeatReporter) ping(url string, body string) {
+	if url != "" {
+		r.pings <- &heartbeatPing{url, body}
+	}
+}
+
+// finishBody returns the body of a success or failure ping with the result of the last
+// attempt and the end of its output.
+func (r *heartbeatReporter) finishBody() string {
+	var b strings.Builder
+	b.WriteString(r.message())
+	if note := r.sr.catchUpNote(); note != "" {
+		fmt.Fprintf(&b, "%s\n", note)
+	}
+	if r.lastResult != "" {
+		fmt.Fprintf(&b, "last attempt: %s\n", r.lastResult)
+	}
+
+	r.excerptMu.Lock()
+	defer r.excerptMu.Unlock()
+	if len(r.excerpt) > 0 {
+		if r.excerptDropped {
+			fmt.Fprintf(&b, "--- last %d bytes of output ---\n", len(r.excerpt))
+		} else {
+			b.WriteString("--- output ---\n")
+		}
+		b.Write(r.excerpt)
+	}
+	return b.String()
+}
+
+func (r *heartbeatReporter) appendExcerpt(count int, log string) {
+	if r.excerptSize == 0 {
+		return
+	}
+	r.excerptMu.Lock()
+	defer r.excerptMu.Unlock()
+
+	// only the output of the last attempt is kept
+	if count != r.excerptAttempt {
+		r.excerpt = r.excerpt[:0]
+		r.excerptAttempt = count
+		r.excerptDropped = false
+	}
+	r.excerpt = append(r.excerpt, log...)
+	if over := len(r.excerpt) - r.excerptSize; over > 0 {
+		r.excerpt = append(r.excerpt[:0], r.excerpt[over:]...)
+		r.excerptDropped = true
+	}
+}
+
+// commandLock pings the failure URL only when the command has not run due to a timeout.
+// A skipped run pings nothing, so that the monitor notices if the running instance hangs.
+func (r *heartbeatReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.sr.commandLock(decision, holderPid, at, waited)
+	message := r.message()
+	if decision == LockWaitTimeout {
+		r.ping(r.failURL, message)
+	}
+}
+
+func (r *heartbeatReporter) commandStart(startAt time.Time) {
+	r.sr.commandStart(startAt)
+	r.ping(r.startURL, r.message())
+}
+
+func (r *heartbeatReporter) commandSucceed(endAt time.Time, duration time.Duration) {
+	r.sr.commandSucceed(endAt, duration)
+	r.ping(r.successURL, r.finishBody())
+}
+
+func (r *heartbeatReporter) commandFail(endAt time.Time, duration time.Duration) {
+	r.sr.commandFail(endAt, duration)
+	r.ping(r.failURL, r.finishBody())
+}
+
+// attemptStart pings nothing, since the monitor is only interested in the command.
+func (r *heartbeatReporter) attemptStart(count int, pid int, startAt time.Time) {
+}
+
+func (r *heartbeatReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.lastResult = "exit status 0"
+}
+
+func (r *heartbeatReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.lastResult = err.Error()
+}
+
+func (r *heartbeatReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.lastResult = "timeout"
+}
+
+func (r *heartbeatReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.lastResult = "out of memory"
+}
+
+func (r *heartbeatReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.lastResult = criterion
+}
+
+func (r *heartbeatReporter) attemptUnknownError(count int, err error, endAt time.Time) {
+	r.lastResult = fmt.Sprintf("unknown error. %s", err)
+}
+
+func (r *heartbeatReporter) startStdoutLogger(count int) {
+	r.stdoutCount = count
+}
+
+func (r *heartbeatReporter) stdoutLog(log string) {
+	r.appendExcerpt(r.stdoutCount, log)
+}
+
+func (r *heartbeatReporter) startStderrLogger(count int) {
+	r.stderrCount = count
+}
+
+func (r *heartbeatReporter) stderrLog(log string) {
+	r.appendExcerpt(r.stderrCount, log)
+}
+
+// close waits for remaining pings up to heartbeatCloseTimeout.
+func (r *heartbeatReporter) close() {
+	close(r.pings)
+	select {
+	case <-r.done:
+	case <-time.After(heartbeatCloseTimeout):
+		close(r.abort)
+		fmt.Fprintf(os.Stderr, "gave up pings which have not been sent in %s\n", heartbeatCloseTimeout)
+	}
+}
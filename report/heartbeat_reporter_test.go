@@ -0,0 +1,73 @@
+package report
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatReporterPings(t *testing.T) {
+	var mu sync.Mutex
+	var paths, bodies []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, string(body))
+		if r.URL.Path == "/fail" && failures > 0 {
+			failures--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	r, err := newHeartbeatReporter("id", "name", &HeartbeatConfig{
+		StartURL:    server.URL + "/start",
+		FailURL:     server.URL + "/fail",
+		Retries:     1,
+		ExcerptSize: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	r.commandStart(now)
+	r.startStdoutLogger(1)
+	r.stdoutLog("hello\n")
+	r.commandFail(now, time.Second)
+	r.close()
+
+	want := []string{"/start", "/fail", "/fail"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Fatalf("got pings %v, want %v", paths, want)
+	}
+	if !strings.HasSuffix(bodies[2], "--- last 4 bytes of output ---\nllo\n") {
+		t.Errorf("got body %q", bodies[2])
+	}
+}
+
+func TestHeartbeatReporterAbort(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	r, err := newHeartbeatReporter("id", "name", &HeartbeatConfig{StartURL: server.URL, Retries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(r.abort)
+	start := time.Now()
+	r.send(&heartbeatPing{server.URL, ""})
+	if elapsed := time.Since(start); elapsed >= heartbeatMinRetryWait {
+		t.Errorf("send retried for %s after abort", elapsed)
+	}
+	close(r.pings)
+	<-r.done
+}
@@ -0,0 +1,318 @@
+package report
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const journaldDefaultSocketPath = "/run/systemd/journal/socket"
+
+// journaldReservedFields are fields written by the reporter itself or interpreted specially
+// by journald. Labels cannot override them.
+var journaldReservedFields = map[string]bool{
+	"MESSAGE":            true,
+	"MESSAGE_ID":         true,
+	"PRIORITY":           true,
+	"CODE_FILE":          true,
+	"CODE_LINE":          true,
+	"CODE_FUNC":          true,
+	"ERRNO":              true,
+	"INVOCATION_ID":      true,
+	"USER_INVOCATION_ID": true,
+	"SYSLOG_FACILITY":    true,
+	"SYSLOG_IDENTIFIER":  true,
+	"SYSLOG_PID":         true,
+	"SYSLOG_TIMESTAMP":   true,
+	"SYSLOG_RAW":         true,
+	"DOCUMENTATION":      true,
+	"TID":                true,
+}
+
+// journaldFieldPrefix is the prefix of fields of the reporter other than the reserved ones.
+const journaldFieldPrefix = "GO_JOB_"
+
+// syslog priorities of journal entries
+const (
+	journaldPriorityError = "3"
+	journaldPriorityInfo  = "6"
+)
+
+type journaldReporter struct {
+	commandId   string
+	commandName string
+	identifier  string
+	labels      map[string]string
+	conn        *net.UnixConn
+	socketAddr  *net.UnixAddr
+
+	// only the first failure is printed, and the number of failures is printed at the end
+	failureMu sync.Mutex
+	failures  int
+
+	stdoutAttempt int
+	stderrAttempt int
+	stdoutLines   lineBuffer
+	stderrLines   lineBuffer
+
+	*eventFormatter
+}
+
+func newJournaldReporter(commandId string, commandName string, config *JournaldConfig, labels map[string]string) (*journaldReporter, error) {
+	socketPath := config.SocketPath
+	if socketPath == "" {
+		socketPath = journaldDefaultSocketPath
+	}
+	socketAddr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	identifier := config.Identifier
+	if identifier == "" {
+		identifier = commandName
+	}
+
+	return &journaldReporter{
+		commandId:      commandId,
+		commandName:    commandName,
+		identifier:     identifier,
+		labels:         journaldLabels(labels),
+		conn:           conn,
+		socketAddr:     socketAddr,
+		eventFormatter: newEventFormatter(commandId, commandName),
+	}, nil
+}
+
+// journaldLabels converts label keys to journal field names, which consist of uppercase
+// letters, digits and underscores and do not start with an underscore. Labels which would
+// override a reserved field or a field of the reporter are dropped.
+func journaldLabels(labels map[string]string) map[string]string {
+	ret := make(map[string]string)
+	for k, v := range labels {
+		name := strings.Map(func(c rune) rune {
+			switch {
+			case 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+				return c
+			case 'a' <= c && c <= 'z':
+				return c - 'a' + 'A'
+			}
+			return '_'
+		}, k)
+		name = strings.TrimLeft(name, "_")
+		if name == "" || ('0' <= name[0] && name[0] <= '9') {
+			fmt.Fprintf(os.Stderr, "label %s cannot be a journal field name\n", k)
+			continue
+		}
+		if journaldReservedFields[name] || strings.HasPrefix(name, journaldFieldPrefix) {
+			fmt.Fprintf(os.Stderr, "label %s conflicts with journal field %s\n", k, name)
+			continue
+		}
+		ret[name] = v
+	}
+	return ret
+}
+
+func appendJournaldField(b []byte, name string, value string) []byte {
+	if !strings.ContainsRune(value, '\n') {
+		b = append(b, name...)
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+	// a value containing newlines is serialized with its length
+	b = append(b, name...)
+	b = append(b, '\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b = append(b, size[:]...)
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+func (r *journaldReporter) send(message string, priority string, fields map[string]string) {
+	b := make([]byte, 0, 512)
+	b = appendJournaldField(b, "MESSAGE", message)
+	b = appendJournaldField(b, "PRIORITY", priority)
+	b = appendJournaldField(b, "SYSLOG_IDENTIFIER", r.identifier)
+	b = appendJournaldField(b, journaldFieldPrefix+"ID", r.commandId)
+	b = appendJournaldField(b, journaldFieldPrefix+"NAME", r.commandName)
+	for k, v := range r.labels {
+		b = appendJournaldField(b, k, v)
+	}
+	for k, v := range fields {
+		b = appendJournaldField(b, journaldFieldPrefix+k, v)
+	}
+
+	if err := r.write(b); err != nil {
+		r.failureMu.Lock()
+		if r.failures == 0 {
+			fmt.Fprintf(os.Stderr, "failed to write to journal. %s\n", err)
+		}
+		r.failures++
+		r.failureMu.Unlock()
+	}
+}
+
+func (r *journaldReporter) sendEvent(event string, priority string, fields map[string]string) {
+	fields["EVENT"] = event
+	r.send(r.trimmedMessage(), priority, fields)
+}
+
+func (r *journaldReporter) sendLine(stream string, count int, line string) {
+	priority := journaldPriorityInfo
+	if stream == stderrTag {
+		priority = journaldPriorityError
+	}
+	r.send(line, priority, map[string]string{
+		"STREAM":  stream,
+		"ATTEMPT": strconv.Itoa(count),
+	})
+}
+
+func (r *journaldReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.sr.commandLock(decision, holderPid, at, waited)
+	priority := journaldPriorityInfo
+	if decision == LockWaitTimeout {
+		priority = journaldPriorityError
+	}
+	r.sendEvent(commandLockTag, priority, map[string]string{
+		"LOCK_DECISION":   decision,
+		"LOCK_HOLDER_PID": strconv.Itoa(holderPid),
+	})
+}
+
+func (r *journaldReporter) commandStart(startAt time.Time) {
+	r.sr.commandStart(startAt)
+	r.sendEvent(commandStartTag, journaldPriorityInfo, map[string]string{})
+}
+
+func (r *journaldReporter) commandSucceed(endAt time.Time, duration time.Duration) {
+	r.sr.commandSucceed(endAt, duration)
+	r.sendEvent(commandSucceedTag, journaldPriorityInfo, map[string]string{})
+}
+
+func (r *journaldReporter) commandFail(endAt time.Time, duration time.Duration) {
+	r.sr.commandFail(endAt, duration)
+	r.sendEvent(commandFailTag, journaldPriorityError, map[string]string{})
+}
+
+func (r *journaldReporter) attemptStart(count int, pid int, startAt time.Time) {
+	r.sr.attemptStart(count, pid, startAt)
+	r.sendEvent(attemptStartTag, journaldPriorityInfo, map[string]string{
+		"ATTEMPT": strconv.Itoa(count),
+		"PID":     strconv.Itoa(pid),
+	})
+}
+
+func (r *journaldReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptSucceed(count, endAt, duration, usage)
+	r.sendEvent(attemptSucceedTag, journaldPriorityInfo, withJournaldUsage(map[string]string{
+		"ATTEMPT":   strconv.Itoa(count),
+		"EXIT_CODE": "0",
+	}, usage))
+}
+
+func (r *journaldReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptFail(count, err, endAt, duration, usage)
+	code, signal, signaled := exitStatus(err)
+	fields := map[string]string{
+		"ATTEMPT":   strconv.Itoa(count),
+		"EXIT_CODE": strconv.Itoa(code),
+	}
+	if signaled {
+		fields["SIGNAL"] = strconv.Itoa(signal)
+	}
+	r.sendEvent(attemptFailTag, journaldPriorityError, withJournaldUsage(fields, usage))
+}
+
+func (r *journaldReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptTimeout(count, endAt, duration, usage)
+	r.sendEvent(attemptTimeoutTag, journaldPriorityError, withJournaldUsage(map[string]string{
+		"ATTEMPT": strconv.Itoa(count),
+	}, usage))
+}
+
+func (r *journaldReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptOOM(count, err, endAt, duration, usage)
+	code, signal, signaled := exitStatus(err)
+	fields := map[string]string{
+		"ATTEMPT":   strconv.Itoa(count),
+		"EXIT_CODE": strconv.Itoa(code),
+	}
+	if signaled {
+		fields["SIGNAL"] = strconv.Itoa(signal)
+	}
+	r.sendEvent(attemptOOMTag, journaldPriorityError, withJournaldUsage(fields, usage))
+}
+
+func (r *journaldReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptCriteriaFail(count, criterion, endAt, duration, usage)
+	r.sendEvent(attemptCriteriaFailTag, journaldPriorityError, withJournaldUsage(map[string]string{
+		"ATTEMPT":   strconv.Itoa(count),
+		"CRITERION": criterion,
+	}, usage))
+}
+
+func (r *journaldReporter) attemptUnknownError(count int, err error, endAt time.Time) {
+	r.sr.attemptUnknownError(count, err, endAt)
+	r.sendEvent(attemptUnknownErrorTag, journaldPriorityError, map[string]string{
+		"ATTEMPT": strconv.Itoa(count),
+	})
+}
+
+func (r *journaldReporter) startStdoutLogger(count int) {
+	r.stdoutAttempt = count
+}
+
+func (r *journaldReporter) finishStdoutLogger() {
+	if line, ok := r.stdoutLines.flush(); ok {
+		r.sendLine(stdoutTag, r.stdoutAttempt, line)
+	}
+}
+
+func (r *journaldReporter) stdoutLog(log string) {
+	for _, line := range r.stdoutLines.lines(log) {
+		r.sendLine(stdoutTag, r.stdoutAttempt, line)
+	}
+}
+
+func (r *journaldReporter) startStderrLogger(count int) {
+	r.stderrAttempt = count
+}
+
+func (r *journaldReporter) finishStderrLogger() {
+	if line, ok := r.stderrLines.flush(); ok {
+		r.sendLine(stderrTag, r.stderrAttempt, line)
+	}
+}
+
+func (r *journaldReporter) stderrLog(log string) {
+	for _, line := range r.stderrLines.lines(log) {
+		r.sendLine(stderrTag, r.stderrAttempt, line)
+	}
+}
+
+func (r *journaldReporter) close() {
+	r.conn.Close()
+	if r.failures > 0 {
+		fmt.Fprintf(os.Stderr, "%d journal entries have been dropped\n", r.failures)
+	}
+}
+
+// withJournaldUsage adds the resource usage to fields of the end of an attempt.
+func withJournaldUsage(fields map[string]string, usage *ResourceUsage) map[string]string {
+	if usage != nil {
+		for k, v := range usage.fields() {
+			fields[strings.ToUpper(formatKey(k, KeyStyleSnake))] = fmt.Sprint(v)
+		}
+	}
+	return fields
+}
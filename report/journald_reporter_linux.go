@@ -0,0 +1,41 @@
+package report
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+)
+
+// write sends an entry as a datagram. An entry too large for a datagram is written to an
+// unlinked temporary file whose descriptor is passed to journald instead.
+func (r *journaldReporter) write(b []byte) error {
+	_, _, err := r.conn.WriteMsgUnix(b, nil, r.socketAddr)
+	if err == nil {
+		return nil
+	}
+	if opErr, ok := err.(*net.OpError); !ok || !isMessageTooLarge(opErr.Err) {
+		return err
+	}
+
+	f, err := ioutil.TempFile("/dev/shm", "go-job-journal-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	_, _, err = r.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), r.socketAddr)
+	return err
+}
+
+func isMessageTooLarge(err error) bool {
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package report
+
+// write sends an entry as a datagram. journald runs only on Linux, so an entry too large for
+// a datagram is not passed as a file.
+func (r *journaldReporter) write(b []byte) error {
+	_, _, err := r.conn.WriteMsgUnix(b, nil, r.socketAddr)
+	return err
+}
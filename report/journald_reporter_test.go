@@ -0,0 +1,103 @@
+package report
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJournaldLabels(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   map[string]string
+	}{
+		{map[string]string{"env": "prod"}, map[string]string{"ENV": "prod"}},
+		{map[string]string{"team-name": "a"}, map[string]string{"TEAM_NAME": "a"}},
+		{map[string]string{"_hidden": "a"}, map[string]string{"HIDDEN": "a"}},
+		{map[string]string{"1st": "a"}, map[string]string{}},
+		{map[string]string{"-": "a"}, map[string]string{}},
+		{map[string]string{"message": "a"}, map[string]string{}},
+		{map[string]string{"priority": "a"}, map[string]string{}},
+		{map[string]string{"syslog_identifier": "a"}, map[string]string{}},
+		{map[string]string{"go_job_id": "a"}, map[string]string{}},
+		{map[string]string{"go-job-event": "a"}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := journaldLabels(tt.labels); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("journaldLabels(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestAppendJournaldField(t *testing.T) {
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, 3)
+	tests := []struct {
+		name  string
+		value string
+		want  []byte
+	}{
+		{"MESSAGE", "hello", []byte("MESSAGE=hello\n")},
+		{"MESSAGE", "", []byte("MESSAGE=\n")},
+		{"MESSAGE", "a\nb", append(append([]byte("MESSAGE\n"), size...), "a\nb\n"...)},
+	}
+	for _, tt := range tests {
+		if got := appendJournaldField(nil, tt.name, tt.value); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendJournaldField(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestJournaldReporterSend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	journal, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot listen on a unix socket. %s", err)
+	}
+	defer journal.Close()
+
+	r, err := newJournaldReporter("id", "name", &JournaldConfig{SocketPath: path}, map[string]string{"env": "prod", "message": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.commandStart(time.Now())
+	r.close()
+
+	buf := make([]byte, 4096)
+	journal.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := journal.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{}
+	for _, line := range bytes.Split(bytes.TrimRight(buf[:n], "\n"), []byte("\n")) {
+		kv := bytes.SplitN(line, []byte("="), 2)
+		if len(kv) != 2 {
+			t.Fatalf("malformed field %q", line)
+		}
+		if _, ok := fields[string(kv[0])]; ok {
+			t.Errorf("duplicated field %s", kv[0])
+		}
+		fields[string(kv[0])] = string(kv[1])
+	}
+	want := map[string]string{
+		"PRIORITY":          journaldPriorityInfo,
+		"SYSLOG_IDENTIFIER": "name",
+		"GO_JOB_ID":         "id",
+		"GO_JOB_NAME":       "name",
+		"GO_JOB_EVENT":      commandStartTag,
+		"ENV":               "prod",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %s = %q, want %q", k, fields[k], v)
+		}
+	}
+	if fields["MESSAGE"] == "x" {
+		t.Errorf("label overrides MESSAGE")
+	}
+}
@@ -0,0 +1,26 @@
+package report
+
+import (
+	"strings"
+)
+
+// lineBuffer splits output chunks into lines. A line without a trailing newline is kept
+// until it is completed or flushed.
+type lineBuffer struct {
+	partial string
+}
+
+// lines returns complete lines in the chunk without their trailing newlines.
+func (b *lineBuffer) lines(chunk string) []string {
+	s := b.partial + chunk
+	ret := strings.Split(s, "\n")
+	b.partial = ret[len(ret)-1]
+	return ret[:len(ret)-1]
+}
+
+// flush returns the partial line, if any.
+func (b *lineBuffer) flush() (string, bool) {
+	s := b.partial
+	b.partial = ""
+	return s, s != ""
+}
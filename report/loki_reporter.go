@@ -0,0 +1,484 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	lokiDefaultBatchSize  = 1 << 20
+	lokiDefaultBatchWait  = time.Second
+	lokiDefaultTimeout    = 10 * time.Second
+	lokiDefaultMaxRetries = 5
+	lokiMinBackoff        = 500 * time.Millisecond
+	lokiMaxBackoff        = 30 * time.Second
+	lokiLifecycleStream   = "lifecycle"
+	lokiBufferSize        = 1024
+	lokiMaxPendingBatches = 8
+
+	// lokiDefaultFlushTimeout bounds the wait for remaining entries at the end of the command
+	lokiDefaultFlushTimeout = 5 * time.Second
+)
+
+// lokiMetadataLabels are labels which have a distinct value for each run. They are sent as
+// structured metadata instead of stream labels not to make a new stream for each run.
+var lokiMetadataLabels = map[string]bool{
+	"scheduled_at": true,
+	"workflow_id":  true,
+}
+
+// lokiStatuses maps lifecycle events to the value of the status label.
+var lokiStatuses = map[string]string{
+	commandStartTag:        "started",
+	commandSucceedTag:      "succeeded",
+	commandFailTag:         "failed",
+	attemptStartTag:        "started",
+	attemptSucceedTag:      "succeeded",
+	attemptFailTag:         "failed",
+	attemptTimeoutTag:      "timeout",
+	attemptOOMTag:          "oom",
+	attemptCriteriaFailTag: "criteria_failed",
+	attemptUnknownErrorTag: "unknown_error",
+}
+
+type lokiReporter struct {
+	commandId   string
+	commandName string
+	hostname    string
+	labels      map[string]string
+	metadata    bool
+	client      *lokiClient
+
+	// attempt counts of output streams, which are sent as structured metadata
+	stdoutAttempt int
+	stderrAttempt int
+
+	stdoutLines lineBuffer
+	stderrLines lineBuffer
+
+	*eventFormatter
+}
+
+func newLokiReporter(commandId string, commandName string, config *LokiConfig, labels map[string]string) (*lokiReporter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("loki url must be specified")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &lokiReporter{
+		commandId:      commandId,
+		commandName:    commandName,
+		hostname:       hostname,
+		labels:         labels,
+		metadata:       config.StructuredMetadata,
+		client:         newLokiClient(config),
+		eventFormatter: newEventFormatter(commandId, commandName),
+	}, nil
+}
+
+func (r *lokiReporter) streamLabels(stream string, status string) map[string]string {
+	ret := map[string]string{}
+	for k, v := range r.labels {
+		if !lokiMetadataLabels[k] {
+			ret[k] = v
+		}
+	}
+	ret["command_name"] = r.commandName
+	ret["host"] = r.hostname
+	ret["stream"] = stream
+	if status != "" {
+		ret["status"] = status
+	}
+	return ret
+}
+
+// entryMetadata returns structured metadata of an entry, which requires Loki 2.9 or later.
+// Labels in lokiMetadataLabels are sent only in it.
+func (r *lokiReporter) entryMetadata(attempt int) map[string]string {
+	if !r.metadata {
+		return nil
+	}
+	ret := map[string]string{"command_id": r.commandId}
+	for k, v := range r.labels {
+		if lokiMetadataLabels[k] {
+			ret[k] = v
+		}
+	}
+	if attempt > 0 {
+		ret["attempt"] = strconv.Itoa(attempt)
+	}
+	return ret
+}
+
+// pushEvent pushes the message of the event as a lifecycle line.
+func (r *lokiReporter) pushEvent(event string, attempt int, tm time.Time) {
+	r.client.push(r.streamLabels(lokiLifecycleStream, lokiStatuses[event]), tm, r.trimmedMessage(), r.entryMetadata(attempt))
+}
+
+func (r *lokiReporter) pushLine(stream string, attempt int, tm time.Time, line string) {
+	r.client.push(r.streamLabels(stream, ""), tm, line, r.entryMetadata(attempt))
+}
+
+func (r *lokiReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	r.sr.commandLock(decision, holderPid, at, waited)
+	message := r.trimmedMessage()
+	// the decision is the status, e.g. status="skipped"
+	r.client.push(r.streamLabels(lokiLifecycleStream, decision), at, message, r.entryMetadata(0))
+}
+
+func (r *lokiReporter) commandStart(startAt time.Time) {
+	r.sr.commandStart(startAt)
+	r.pushEvent(commandStartTag, 0, startAt)
+}
+
+func (r *lokiReporter) commandSucceed(endAt time.Time, duration time.Duration) {
+	r.sr.commandSucceed(endAt, duration)
+	r.pushEvent(commandSucceedTag, 0, endAt)
+}
+
+func (r *lokiReporter) commandFail(endAt time.Time, duration time.Duration) {
+	r.sr.commandFail(endAt, duration)
+	r.pushEvent(commandFailTag, 0, endAt)
+}
+
+func (r *lokiReporter) attemptStart(count int, pid int, startAt time.Time) {
+	r.sr.attemptStart(count, pid, startAt)
+	r.pushEvent(attemptStartTag, count, startAt)
+}
+
+func (r *lokiReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptSucceed(count, endAt, duration, usage)
+	r.pushEvent(attemptSucceedTag, count, endAt)
+}
+
+func (r *lokiReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptFail(count, err, endAt, duration, usage)
+	r.pushEvent(attemptFailTag, count, endAt)
+}
+
+func (r *lokiReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptTimeout(count, endAt, duration, usage)
+	r.pushEvent(attemptTimeoutTag, count, endAt)
+}
+
+func (r *lokiReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptOOM(count, err, endAt, duration, usage)
+	r.pushEvent(attemptOOMTag, count, endAt)
+}
+
+func (r *lokiReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.sr.attemptCriteriaFail(count, criterion, endAt, duration, usage)
+	r.pushEvent(attemptCriteriaFailTag, count, endAt)
+}
+
+func (r *lokiReporter) attemptUnknownError(count int, err error, endAt time.Time) {
+	r.sr.attemptUnknownError(count, err, endAt)
+	r.pushEvent(attemptUnknownErrorTag, count, endAt)
+}
+
+func (r *lokiReporter) startStdoutLogger(count int) {
+	r.stdoutAttempt = count
+}
+
+func (r *lokiReporter) finishStdoutLogger() {
+	if line, ok := r.stdoutLines.flush(); ok {
+		r.pushLine(stdoutTag, r.stdoutAttempt, time.Now(), line)
+	}
+}
+
+func (r *lokiReporter) stdoutLog(log string) {
+	now := time.Now()
+	for _, line := range r.stdoutLines.lines(log) {
+		r.pushLine(stdoutTag, r.stdoutAttempt, now, line)
+	}
+}
+
+func (r *lokiReporter) startStderrLogger(count int) {
+	r.stderrAttempt = count
+}
+
+func (r *lokiReporter) finishStderrLogger() {
+	if line, ok := r.stderrLines.flush(); ok {
+		r.pushLine(stderrTag, r.stderrAttempt, time.Now(), line)
+	}
+}
+
+func (r *lokiReporter) stderrLog(log string) {
+	now := time.Now()
+	for _, line := range r.stderrLines.lines(log) {
+		r.pushLine(stderrTag, r.stderrAttempt, now, line)
+	}
+}
+
+func (r *lokiReporter) close() {
+	r.client.close()
+}
+
+type lokiEntry struct {
+	labels   map[string]string
+	time     time.Time
+	line     string
+	metadata map[string]string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	// each value is [timestamp, line] or [timestamp, line, metadata]
+	Values [][]interface{} `json:"values"`
+}
+
+type lokiPushRequest struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+// lokiClient batches entries and pushes them to the Loki push API in background. A batch
+// is pushed when it exceeds batchSize bytes or batchWait has passed since its first entry.
+// Entries are dropped rather than blocking the command output when Loki cannot keep up.
+type lokiClient struct {
+	url          string
+	tenantId     string
+	username     string
+	password     string
+	batchSize    int
+	batchWait    time.Duration
+	maxRetries   int
+	flushTimeout time.Duration
+	httpClient   *http.Client
+
+	entries chan *lokiEntry
+	batches chan *lokiBatch
+	done    chan struct{}
+	// abort is closed to stop retries when close has given up waiting
+	abort   chan struct{}
+	dropped int64
+}
+
+func newLokiClient(config *LokiConfig) *lokiClient {
+	c := &lokiClient{
+		url:          config.URL,
+		tenantId:     config.TenantId,
+		username:     config.Username,
+		password:     config.Password,
+		batchSize:    config.BatchSize,
+		batchWait:    config.BatchWait,
+		maxRetries:   config.MaxRetries,
+		flushTimeout: config.FlushTimeout,
+		httpClient:   &http.Client{Timeout: config.Timeout},
+		entries:      make(chan *lokiEntry, lokiBufferSize),
+		batches:      make(chan *lokiBatch, lokiMaxPendingBatches),
+		done:         make(chan struct{}),
+		abort:        make(chan struct{}),
+	}
+	if c.batchSize <= 0 {
+		c.batchSize = lokiDefaultBatchSize
+	}
+	if c.batchWait <= 0 {
+		c.batchWait = lokiDefaultBatchWait
+	}
+	if c.maxRetries <= 0 {
+		c.maxRetries = lokiDefaultMaxRetries
+	}
+	if c.httpClient.Timeout <= 0 {
+		c.httpClient.Timeout = lokiDefaultTimeout
+	}
+	if c.flushTimeout <= 0 {
+		c.flushTimeout = lokiDefaultFlushTimeout
+	}
+	go c.run()
+	go c.sendLoop()
+	return c
+}
+
+// push queues the entry, or drops it if the buffer is full.
+func (c *lokiClient) push(labels map[string]string, tm time.Time, line string, metadata map[string]string) {
+	select {
+	case c.entries <- &lokiEntry{labels, tm, line, metadata}:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// close waits for remaining entries to be pushed up to flushTimeout.
+func (c *lokiClient) close() {
+	close(c.entries)
+	select {
+	case <-c.done:
+	case <-time.After(c.flushTimeout):
+		close(c.abort)
+		fmt.Fprintf(os.Stderr, "gave up entries to loki which have not been pushed in %s\n", c.flushTimeout)
+	}
+	if dropped := atomic.LoadInt64(&c.dropped); dropped > 0 {
+		fmt.Fprintf(os.Stderr, "dropped %d entries to loki since loki could not keep up\n", dropped)
+	}
+}
+
+// run batches entries and passes them to sendLoop. It never waits for a push, so that the
+// buffer of entries is drained while sendLoop retries.
+func (c *lokiClient) run() {
+	defer close(c.batches)
+
+	batch := newLokiBatch()
+	var timer <-chan time.Time
+	for {
+		select {
+		case e, ok := <-c.entries:
+			if !ok {
+				if batch.size > 0 {
+					c.batches <- batch
+				}
+				return
+			}
+			if batch.size == 0 {
+				timer = time.After(c.batchWait)
+			}
+			batch.add(e)
+			if batch.size >= c.batchSize {
+				c.enqueue(batch)
+				batch, timer = newLokiBatch(), nil
+			}
+		case <-timer:
+			c.enqueue(batch)
+			batch, timer = newLokiBatch(), nil
+		}
+	}
+}
+
+// enqueue passes the batch to sendLoop, or drops it if too many batches are pending.
+func (c *lokiClient) enqueue(batch *lokiBatch) {
+	select {
+	case c.batches <- batch:
+	default:
+		atomic.AddInt64(&c.dropped, int64(batch.count))
+	}
+}
+
+func (c *lokiClient) sendLoop() {
+	defer close(c.done)
+	for batch := range c.batches {
+		c.send(batch)
+	}
+}
+
+// send pushes the batch, and retries on network errors, 429 and 5xx responses.
+func (c *lokiClient) send(batch *lokiBatch) {
+	body, err := json.Marshal(batch.request())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode loki entries. %s\n", err)
+		return
+	}
+
+	backoff := lokiMinBackoff
+	for i := 0; ; i++ {
+		retry, err := c.post(body)
+		if err == nil {
+			return
+		}
+		if !retry || i >= c.maxRetries {
+			fmt.Fprintf(os.Stderr, "failed to push %d entries to loki. %s\n", batch.count, err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-c.abort:
+			fmt.Fprintf(os.Stderr, "failed to push %d entries to loki. %s\n", batch.count, err)
+			return
+		}
+		if backoff *= 2; backoff > lokiMaxBackoff {
+			backoff = lokiMaxBackoff
+		}
+	}
+}
+
+func (c *lokiClient) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.tenantId != "" {
+		req.Header.Set("X-Scope-OrgID", c.tenantId)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	err = fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode/100 == 5, err
+}
+
+type lokiBatch struct {
+	streams map[string]*lokiStream
+	size    int
+	count   int
+}
+
+func newLokiBatch() *lokiBatch {
+	return &lokiBatch{streams: make(map[string]*lokiStream)}
+}
+
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(strconv.Quote(k))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+func (b *lokiBatch) add(e *lokiEntry) {
+	key := lokiStreamKey(e.labels)
+	s, ok := b.streams[key]
+	if !ok {
+		s = &lokiStream{Stream: e.labels, Values: make([][]interface{}, 0)}
+		b.streams[key] = s
+	}
+	value := []interface{}{strconv.FormatInt(e.time.UnixNano(), 10), e.line}
+	if e.metadata != nil {
+		value = append(value, e.metadata)
+	}
+	s.Values = append(s.Values, value)
+	b.size += len(e.line)
+	b.count++
+}
+
+func (b *lokiBatch) request() *lokiPushRequest {
+	req := &lokiPushRequest{Streams: make([]*lokiStream, 0, len(b.streams))}
+	for _, s := range b.streams {
+		req.Streams = append(req.Streams, s)
+	}
+	return req
+}
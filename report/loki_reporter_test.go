@@ -0,0 +1,131 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type lokiStandIn struct {
+	mu       sync.Mutex
+	requests []lokiPushRequest
+}
+
+func (s *lokiStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req lokiPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLokiReporterLabels(t *testing.T) {
+	standIn := &lokiStandIn{}
+	server := httptest.NewServer(standIn)
+	defer server.Close()
+
+	labels := map[string]string{"env": "test", "scheduled_at": "2026-01-02T03:04:05Z", "workflow_id": "wf"}
+	r, err := newLokiReporter("id", "name", &LokiConfig{URL: server.URL, StructuredMetadata: true}, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.commandStart(time.Now())
+	r.stdoutLog("hello\n")
+	r.close()
+
+	if len(standIn.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(standIn.requests))
+	}
+	streams := standIn.requests[0].Streams
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(streams))
+	}
+	for _, s := range streams {
+		if s.Stream["env"] != "test" || s.Stream["command_name"] != "name" {
+			t.Errorf("stream %v lacks labels", s.Stream)
+		}
+		if _, ok := s.Stream["scheduled_at"]; ok {
+			t.Errorf("stream %v has scheduled_at", s.Stream)
+		}
+		if _, ok := s.Stream["workflow_id"]; ok {
+			t.Errorf("stream %v has workflow_id", s.Stream)
+		}
+		for _, v := range s.Values {
+			metadata, _ := v[2].(map[string]interface{})
+			if metadata["scheduled_at"] != labels["scheduled_at"] || metadata["workflow_id"] != "wf" || metadata["command_id"] != "id" {
+				t.Errorf("got metadata %v", v[2])
+			}
+		}
+	}
+}
+
+func TestLokiClientRetry(t *testing.T) {
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&posts, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := newLokiClient(&LokiConfig{URL: server.URL})
+	c.push(map[string]string{"stream": "stdout"}, time.Now(), "line", nil)
+	c.close()
+
+	if posts != 2 {
+		t.Errorf("got %d posts, want 2", posts)
+	}
+}
+
+func TestLokiClientPushDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := newLokiClient(&LokiConfig{URL: server.URL, BatchSize: 1})
+	start := time.Now()
+	for i := 0; i < 100*lokiBufferSize; i++ {
+		c.push(map[string]string{"stream": "stdout"}, time.Now(), "line", nil)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("push blocked for %s while loki is not responding", elapsed)
+	}
+	if atomic.LoadInt64(&c.dropped) == 0 {
+		t.Errorf("no entries are dropped")
+	}
+	close(release)
+	c.close()
+}
+
+func TestLokiClientCloseTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := newLokiClient(&LokiConfig{URL: server.URL, MaxRetries: 100, FlushTimeout: 100 * time.Millisecond})
+	c.push(map[string]string{"stream": "stdout"}, time.Now(), "line", nil)
+	start := time.Now()
+	c.close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("close waited for %s while loki is failing", elapsed)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("retries have not stopped after close")
+	}
+}
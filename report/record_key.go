@@ -0,0 +1,39 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	KeyStyleCamel = "camel"
+	KeyStyleSnake = "snake"
+)
+
+func validateKeyStyle(style string) error {
+	switch style {
+	case "", KeyStyleCamel, KeyStyleSnake:
+		return nil
+	}
+	return fmt.Errorf("unknown key style: %s", style)
+}
+
+// formatKey converts a camelCase key such as "commandId" to the given style.
+func formatKey(key string, style string) string {
+	if style != KeyStyleSnake {
+		return key
+	}
+
+	var b strings.Builder
+	for i, c := range key {
+		if unicode.IsUpper(c) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
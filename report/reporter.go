@@ -5,15 +5,32 @@ import (
 	"time"
 )
 
+// Decisions reported when another instance of the command holds the lock.
+const (
+	LockSkipped = "skipped"
+	LockWaited  = "waited"
+	// LockWaitTimeout means that the command has not run since the lock was not released in time.
+	LockWaitTimeout = "wait_timeout"
+	LockKilled      = "killed"
+)
+
 type reporter interface {
+	commandLock(decision string, holderPid int, at time.Time, waited time.Duration)
 	commandStart(startAt time.Time)
 	commandSucceed(endAt time.Time, duration time.Duration)
 	commandFail(endAt time.Time, duration time.Duration)
 
 	attemptStart(count int, pid int, startAt time.Time)
-	attemptSucceed(count int, startAt time.Time, duration time.Duration)
-	attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration)
-	attemptTimeout(count int, endAt time.Time, duration time.Duration)
+	// usage at the end of an attempt is nil if it is not available.
+	attemptSucceed(count int, startAt time.Time, duration time.Duration, usage *ResourceUsage)
+	attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage)
+	attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage)
+	// attemptOOM is reported instead of attemptFail when the process has been killed for
+	// exceeding the memory limit.
+	attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage)
+	// attemptCriteriaFail is reported instead of attemptSucceed or attemptFail when the process
+	// has exited but has not met the success criteria. criterion describes the unmet one.
+	attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage)
 	attemptUnknownError(count int, err error, endAt time.Time)
 
 	startStdoutLogger(count int)
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// eventFormatter formats events as lines of stringReporter for reporters which send each
+// event as a message. A reporter embeds it, writes an event with sr, and takes the message.
+type eventFormatter struct {
+	buf bytes.Buffer
+	sr  *stringReporter
+}
+
+func newEventFormatter(commandId string, commandName string) *eventFormatter {
+	f := &eventFormatter{}
+	f.sr = &stringReporter{
+		commandId:   commandId,
+		commandName: commandName,
+		fh:          &f.buf,
+	}
+	return f
+}
+
+func (f *eventFormatter) setCatchUpOf(scheduledAt time.Time) {
+	f.sr.setCatchUpOf(scheduledAt)
+}
+
+// message returns the lines written by sr since the last call.
+func (f *eventFormatter) message() string {
+	message := f.buf.String()
+	f.buf.Reset()
+	return message
+}
+
+// trimmedMessage returns the message without the trailing newline.
+func (f *eventFormatter) trimmedMessage() string {
+	return strings.TrimRight(f.message(), "\n")
+}
+
+// reporterDefaults implements output loggers and close of reporter with nothing to do. A
+// reporter embeds it and defines the methods it needs.
+type reporterDefaults struct{}
+
+func (reporterDefaults) startStdoutLogger(count int) {}
+
+func (reporterDefaults) finishStdoutLogger() {}
+
+func (reporterDefaults) startStderrLogger(count int) {}
+
+func (reporterDefaults) finishStderrLogger() {}
+
+func (reporterDefaults) close() {}
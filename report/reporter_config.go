@@ -1,9 +1,142 @@
 package report
 
+import (
+	"time"
+)
+
+const (
+	ReporterConsole   = "console"
+	ReporterFluentd   = "fluentd"
+	ReporterFile      = "file"
+	ReporterLoki      = "loki"
+	ReporterGelf      = "gelf"
+	ReporterJournald  = "journald"
+	ReporterHeartbeat = "heartbeat"
+)
+
 type ReporterConfig struct {
-	Reporters        string
-	FluentdHost      string
-	FluentdPort      int
-	FluentdTagPrefix string
-	FileDirectory    string
+	// Labels are static fields added to every record of reporters which support them.
+	Labels    map[string]string
+	Reporters []*ReporterInstance
+	// FileOwner owns directories created by the file reporter for the command, such as the
+	// user running the command. They are owned by the current user if it is nil.
+	FileOwner *FileOwner
+	// CatchUpOf is the fire time of a scheduled run which is caught up after it has been
+	// missed. It is written in the message of the command start. It is zero for other runs.
+	CatchUpOf time.Time
+}
+
+type FileOwner struct {
+	Uid int
+	Gid int
+}
+
+// ReporterInstance is a reporter with its own options. A type of reporter can have
+// multiple instances with different names.
+type ReporterInstance struct {
+	// Name identifies the instance in messages. It defaults to the type of the reporter.
+	Name    string
+	Options ReporterOptions
+	// Filter selects events passed to the reporter. All events are passed if it is nil.
+	Filter *ReporterFilter
+}
+
+// ReporterOptions is one of the *Config types below.
+type ReporterOptions interface {
+	Type() string
+}
+
+type ConsoleConfig struct{}
+
+func (c *ConsoleConfig) Type() string { return ReporterConsole }
+
+type FluentdConfig struct {
+	Host      string
+	Port      int
+	TagPrefix string
+	// BufferLimit is the maximum number of events buffered in memory. 0 means unlimited.
+	BufferLimit    int
+	SpoolDirectory string
+	FlushTimeout   time.Duration
+	// SocketPath is used instead of Host and Port when it is set.
+	SocketPath            string
+	TLS                   bool
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+	SharedKey             string
+	Username              string
+	Password              string
+	RequireAck            bool
+	AckTimeout            time.Duration
+	// KeyStyle is either "camel" (default) or "snake".
+	KeyStyle    string
+	OmitMessage bool
+}
+
+func (c *FluentdConfig) Type() string { return ReporterFluentd }
+
+type FileConfig struct {
+	Directory   string
+	Retention   RetentionPolicy
+	Compression string
+	OutputLimit int64
+	Combined    bool
+}
+
+func (c *FileConfig) Type() string { return ReporterFile }
+
+type LokiConfig struct {
+	// URL is the URL of the push API, e.g. http://localhost:3100/loki/api/v1/push.
+	URL      string
+	TenantId string
+	Username string
+	Password string
+	// BatchSize is the size of lines in bytes which triggers a push.
+	BatchSize  int
+	BatchWait  time.Duration
+	Timeout    time.Duration
+	MaxRetries int
+	// FlushTimeout bounds the wait for remaining lines to be pushed at the end.
+	FlushTimeout time.Duration
+	// StructuredMetadata attaches command_id, attempt, scheduled_at and workflow_id to each line
+	// as structured metadata. They are never sent as stream labels.
+	StructuredMetadata bool
+}
+
+func (c *LokiConfig) Type() string { return ReporterLoki }
+
+type GelfConfig struct {
+	// Protocol is either "udp" or "tcp".
+	Protocol string
+	Address  string
+	// ChunkSize is the maximum size of a UDP datagram including the chunk header.
+	ChunkSize int
+	// Compression is one of "", "gzip" or "zlib". It is available only for UDP.
+	Compression string
 }
+
+func (c *GelfConfig) Type() string { return ReporterGelf }
+
+type JournaldConfig struct {
+	// SocketPath defaults to /run/systemd/journal/socket.
+	SocketPath string
+	// Identifier is SYSLOG_IDENTIFIER of entries. It defaults to the command name.
+	Identifier string
+}
+
+func (c *JournaldConfig) Type() string { return ReporterJournald }
+
+type HeartbeatConfig struct {
+	// URLs are pinged when the command starts, succeeds and fails. Empty URLs are not pinged.
+	StartURL   string
+	SuccessURL string
+	FailURL    string
+	Timeout    time.Duration
+	Retries    int
+	// ExcerptSize is the size of the end of output sent with success and failure pings.
+	ExcerptSize int
+}
+
+func (c *HeartbeatConfig) Type() string { return ReporterHeartbeat }
@@ -0,0 +1,155 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// EventOutput is the event name of output of both streams.
+	EventOutput = "output"
+
+	OutcomeSuccess      = "success"
+	OutcomeFailure      = "failure"
+	OutcomeTimeout      = "timeout"
+	OutcomeOOM          = "oom"
+	OutcomeUnknownError = "unknown_error"
+
+	AttemptFirst = "first"
+	AttemptLast  = "last"
+)
+
+var filterEvents = []string{
+	commandLockTag,
+	commandStartTag,
+	commandSucceedTag,
+	commandFailTag,
+	attemptStartTag,
+	attemptSucceedTag,
+	attemptFailTag,
+	attemptTimeoutTag,
+	attemptOOMTag,
+	attemptCriteriaFailTag,
+	attemptUnknownErrorTag,
+	EventOutput,
+}
+
+var filterOutcomes = map[string]string{
+	commandSucceedTag:      OutcomeSuccess,
+	commandFailTag:         OutcomeFailure,
+	attemptSucceedTag:      OutcomeSuccess,
+	attemptFailTag:         OutcomeFailure,
+	attemptTimeoutTag:      OutcomeTimeout,
+	attemptOOMTag:          OutcomeOOM,
+	attemptCriteriaFailTag: OutcomeFailure,
+	attemptUnknownErrorTag: OutcomeUnknownError,
+}
+
+// ReporterFilter selects events passed to a reporter. Each condition restricts only the
+// events which it applies to, and an empty condition allows all of them.
+type ReporterFilter struct {
+	// Events are names of events such as "command_fail", or "output" for output lines.
+	Events []string
+	// Streams restricts output to "stdout" or "stderr".
+	Streams []string
+	// Outcomes restricts events at the end of attempts and the command. "failure" also
+	// matches "timeout", "oom" and "unknown_error".
+	Outcomes []string
+	// Attempts restricts events of attempts and output to attempt numbers, "first" or
+	// "last". The last attempt is the one which has run last, i.e. a successful one, the
+	// final one allowed, or the one after which the command has been stopped. Events of an
+	// attempt before the final one allowed are held until it turns out whether it is the last.
+	Attempts []string
+}
+
+func (f *ReporterFilter) Validate() error {
+	for _, e := range f.Events {
+		if !containsString(filterEvents, e) {
+			return fmt.Errorf("unknown event: %s", e)
+		}
+	}
+	for _, s := range f.Streams {
+		if s != stdoutTag && s != stderrTag {
+			return fmt.Errorf("unknown stream: %s", s)
+		}
+	}
+	for _, o := range f.Outcomes {
+		if o != OutcomeSuccess && o != OutcomeFailure && o != OutcomeTimeout && o != OutcomeOOM && o != OutcomeUnknownError {
+			return fmt.Errorf("unknown outcome: %s", o)
+		}
+	}
+	for _, a := range f.Attempts {
+		if a == AttemptFirst || a == AttemptLast {
+			continue
+		}
+		if n, err := strconv.Atoi(a); err != nil || n < 1 {
+			return fmt.Errorf("invalid attempt: %s", a)
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEvent is an event to be matched with filters. Stream and attempt are empty or 0
+// for events which do not have them. last is set if the attempt is known to be the last.
+type filterEvent struct {
+	name    string
+	stream  string
+	attempt int
+	last    bool
+}
+
+type filterResult int
+
+const (
+	filterReject filterResult = iota
+	filterAccept
+	// filterIfLast accepts the event only if its attempt turns out to be the last.
+	filterIfLast
+)
+
+func (f *ReporterFilter) match(e *filterEvent, maxAttempt int) filterResult {
+	if len(f.Events) > 0 && !containsString(f.Events, e.name) {
+		return filterReject
+	}
+	if len(f.Streams) > 0 && e.stream != "" && !containsString(f.Streams, e.stream) {
+		return filterReject
+	}
+	if outcome, ok := filterOutcomes[e.name]; ok && len(f.Outcomes) > 0 {
+		matched := containsString(f.Outcomes, outcome) ||
+			(outcome != OutcomeSuccess && containsString(f.Outcomes, OutcomeFailure))
+		if !matched {
+			return filterReject
+		}
+	}
+	if len(f.Attempts) > 0 && e.attempt > 0 {
+		result := filterReject
+		for _, a := range f.Attempts {
+			switch a {
+			case AttemptFirst:
+				if e.attempt == 1 {
+					return filterAccept
+				}
+			case AttemptLast:
+				if e.last || e.attempt >= maxAttempt {
+					return filterAccept
+				}
+				result = filterIfLast
+			default:
+				if n, _ := strconv.Atoi(a); e.attempt == n {
+					return filterAccept
+				}
+			}
+		}
+		return result
+	}
+	return filterAccept
+}
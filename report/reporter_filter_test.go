@@ -0,0 +1,144 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReporterFilterValidate(t *testing.T) {
+	tests := []struct {
+		filter ReporterFilter
+		want   string
+	}{
+		{ReporterFilter{}, ""},
+		{ReporterFilter{Events: []string{commandFailTag, EventOutput}, Streams: []string{stderrTag}}, ""},
+		{ReporterFilter{Outcomes: []string{OutcomeFailure, OutcomeOOM}, Attempts: []string{AttemptFirst, AttemptLast, "2"}}, ""},
+		{ReporterFilter{Events: []string{"command_crash"}}, "unknown event: command_crash"},
+		{ReporterFilter{Streams: []string{"stdin"}}, "unknown stream: stdin"},
+		{ReporterFilter{Outcomes: []string{"ok"}}, "unknown outcome: ok"},
+		{ReporterFilter{Attempts: []string{"0"}}, "invalid attempt: 0"},
+		{ReporterFilter{Attempts: []string{"second"}}, "invalid attempt: second"},
+	}
+	for _, tt := range tests {
+		err := tt.filter.Validate()
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("Validate(%+v) = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestReporterFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ReporterFilter
+		event  filterEvent
+		want   filterResult
+	}{
+		{"empty", ReporterFilter{}, filterEvent{name: commandStartTag}, filterAccept},
+		{"event", ReporterFilter{Events: []string{commandFailTag}}, filterEvent{name: commandFailTag}, filterAccept},
+		{"other event", ReporterFilter{Events: []string{commandFailTag}}, filterEvent{name: commandSucceedTag}, filterReject},
+		{"output", ReporterFilter{Events: []string{EventOutput}}, filterEvent{name: EventOutput, stream: stdoutTag, attempt: 1}, filterAccept},
+		{"stream", ReporterFilter{Streams: []string{stderrTag}}, filterEvent{name: EventOutput, stream: stderrTag, attempt: 1}, filterAccept},
+		{"other stream", ReporterFilter{Streams: []string{stderrTag}}, filterEvent{name: EventOutput, stream: stdoutTag, attempt: 1}, filterReject},
+		{"stream of an event without stream", ReporterFilter{Streams: []string{stderrTag}}, filterEvent{name: commandStartTag}, filterAccept},
+		{"outcome", ReporterFilter{Outcomes: []string{OutcomeSuccess}}, filterEvent{name: commandSucceedTag}, filterAccept},
+		{"other outcome", ReporterFilter{Outcomes: []string{OutcomeSuccess}}, filterEvent{name: commandFailTag}, filterReject},
+		{"failure matches timeout", ReporterFilter{Outcomes: []string{OutcomeFailure}}, filterEvent{name: attemptTimeoutTag, attempt: 1}, filterAccept},
+		{"failure matches oom", ReporterFilter{Outcomes: []string{OutcomeFailure}}, filterEvent{name: attemptOOMTag, attempt: 1}, filterAccept},
+		{"failure does not match success", ReporterFilter{Outcomes: []string{OutcomeFailure}}, filterEvent{name: attemptSucceedTag, attempt: 1}, filterReject},
+		{"timeout does not match failure", ReporterFilter{Outcomes: []string{OutcomeTimeout}}, filterEvent{name: attemptFailTag, attempt: 1}, filterReject},
+		{"outcome of an event without outcome", ReporterFilter{Outcomes: []string{OutcomeFailure}}, filterEvent{name: attemptStartTag, attempt: 1}, filterAccept},
+		{"first attempt", ReporterFilter{Attempts: []string{AttemptFirst}}, filterEvent{name: attemptStartTag, attempt: 1}, filterAccept},
+		{"not first attempt", ReporterFilter{Attempts: []string{AttemptFirst}}, filterEvent{name: attemptStartTag, attempt: 2}, filterReject},
+		{"last attempt", ReporterFilter{Attempts: []string{AttemptLast}}, filterEvent{name: attemptStartTag, attempt: 3}, filterAccept},
+		{"attempt which may be last", ReporterFilter{Attempts: []string{AttemptLast}}, filterEvent{name: attemptStartTag, attempt: 2}, filterIfLast},
+		{"attempt known to be last", ReporterFilter{Attempts: []string{AttemptLast}}, filterEvent{name: attemptSucceedTag, attempt: 2, last: true}, filterAccept},
+		{"number before last", ReporterFilter{Attempts: []string{AttemptLast, "2"}}, filterEvent{name: attemptStartTag, attempt: 2}, filterAccept},
+		{"attempt number", ReporterFilter{Attempts: []string{AttemptFirst, "2"}}, filterEvent{name: attemptStartTag, attempt: 2}, filterAccept},
+		{"attempt of a command event", ReporterFilter{Attempts: []string{"2"}}, filterEvent{name: commandFailTag}, filterAccept},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.match(&tt.event, 3); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReporterListFilter(t *testing.T) {
+	var all, failures bytes.Buffer
+	newReporter := func(buf *bytes.Buffer) reporter {
+		return &consoleReporter{stringReporter: stringReporter{commandId: "id", commandName: "name", fh: buf, out: buf, err: buf}}
+	}
+	list := ReporterList{
+		reporters: []reporter{newReporter(&all), newReporter(&failures)},
+		filters: []*ReporterFilter{nil, {
+			Events:   []string{attemptFailTag, attemptSucceedTag, EventOutput},
+			Streams:  []string{stderrTag},
+			Attempts: []string{AttemptLast},
+		}},
+		maxAttempt: 3,
+		held:       newHeldEvents(),
+	}
+
+	now := time.Now()
+	list.CommandStart(now)
+	for count := 1; count <= 2; count++ {
+		list.StartStdoutLogger(count)
+		list.StartStderrLogger(count)
+		list.AttemptStart(count, 100, now)
+		list.StdoutLog("out\n")
+		list.StderrLog(fmt.Sprintf("err %d\n", count))
+		list.FinishStdoutLogger()
+		list.FinishStderrLogger()
+		if count == 1 {
+			list.AttemptFail(count, nil, now, time.Second, nil)
+		} else {
+			list.AttemptSucceed(count, now, time.Second, nil)
+		}
+	}
+	list.CommandSucceed(now, time.Second)
+
+	if n := strings.Count(all.String(), "\n"); n != 10 {
+		t.Errorf("unfiltered reporter got %d lines, want 10:\n%s", n, all.String())
+	}
+	lines := strings.Split(strings.TrimRight(failures.String(), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "err 2" || ParseLifecycleLine(lines[1]).Event != attemptSucceedTag || ParseLifecycleLine(lines[1]).Attempt != 2 {
+		t.Errorf("filtered reporter got %q, want stderr and the end of the successful attempt", lines)
+	}
+}
+
+// TestReporterListLastAttempt checks that events held for "last" are passed when the command
+// ends after a failed attempt before the final one allowed, e.g. killed by another instance.
+func TestReporterListLastAttempt(t *testing.T) {
+	var buf bytes.Buffer
+	list := ReporterList{
+		reporters:  []reporter{&consoleReporter{stringReporter: stringReporter{commandId: "id", commandName: "name", fh: &buf, out: &buf, err: &buf}}},
+		filters:    []*ReporterFilter{{Events: []string{attemptFailTag, EventOutput}, Attempts: []string{AttemptLast}}},
+		maxAttempt: 3,
+		held:       newHeldEvents(),
+	}
+
+	now := time.Now()
+	for count := 1; count <= 2; count++ {
+		list.StartStdoutLogger(count)
+		list.StdoutLog(fmt.Sprintf("out %d\n", count))
+		list.FinishStdoutLogger()
+		list.AttemptFail(count, nil, now, time.Second, nil)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("events are passed before the last attempt is known: %q", buf.String())
+	}
+	list.CommandFail(now, time.Second)
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "out 2" || ParseLifecycleLine(lines[1]).Attempt != 2 {
+		t.Errorf("got %q, want the output and the end of the 2nd attempt", lines)
+	}
+}
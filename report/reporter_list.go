@@ -4,153 +4,342 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
 	"sync"
 	"time"
 )
 
-type ReporterList []reporter
-
-func NewReporterList(commandId, commandName string, config *ReporterConfig) (ReporterList, error) {
-	list := make([]reporter, 0)
-
-	for _, s := range strings.Split(config.Reporters, ",") {
-		switch s {
-		case "console":
-			list = append(list, newConsoleReporter(commandId, commandName))
-		case "fluentd":
-			r, err := newFluentdReporter(commandId, commandName, config.FluentdHost, config.FluentdPort, config.FluentdTagPrefix)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to initialize fluentd reporter. %s\n", err)
-			} else {
-				list = append(list, r)
+// ReporterList passes events to reporters whose filter matches them.
+type ReporterList struct {
+	reporters  []reporter
+	filters    []*ReporterFilter
+	maxAttempt int
+
+	// attempts of the current output loggers, which are used to filter output
+	stdoutCount int
+	stderrCount int
+
+	held *heldEvents
+}
+
+// heldEvents are events of an attempt held for each reporter until it turns out whether the
+// attempt is the last. They are dropped when the next attempt starts, and passed when the
+// attempt succeeds or the command ends.
+type heldEvents struct {
+	mu      sync.Mutex
+	attempt map[int]int
+	events  map[int][]func(r reporter)
+}
+
+func newHeldEvents() *heldEvents {
+	return &heldEvents{attempt: make(map[int]int), events: make(map[int][]func(r reporter))}
+}
+
+// resolve returns functions to call on the i-th reporter for the event, including held ones
+// which have turned out to be of the last attempt.
+func (h *heldEvents) resolve(i int, e *filterEvent, result filterResult, f func(r reporter)) []func(r reporter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	held := h.events[i]
+	if len(held) > 0 && e.attempt > h.attempt[i] {
+		// the next attempt has started
+		held = nil
+	}
+	delete(h.events, i)
+
+	switch {
+	case result == filterIfLast:
+		h.events[i] = append(held, f)
+		h.attempt[i] = e.attempt
+		return nil
+	case len(held) > 0 && e.attempt == h.attempt[i] && !e.last:
+		// events of the attempt are still held
+		h.events[i] = held
+		if result == filterAccept {
+			return []func(r reporter){f}
+		}
+		return nil
+	case result == filterAccept:
+		return append(held, f)
+	}
+	return held
+}
+
+func NewReporterList(commandId, commandName string, maxAttempt int, config *ReporterConfig) (ReporterList, error) {
+	list := ReporterList{maxAttempt: maxAttempt, held: newHeldEvents()}
+	if err := validateReporterConfig(config); err != nil {
+		return list, err
+	}
+
+	for _, inst := range config.Reporters {
+		r, err := newReporter(commandId, commandName, inst.Options, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to initialize %s reporter. %s\n", inst.Name, err)
+			continue
+		}
+		if m, ok := r.(catchUpMarker); ok && !config.CatchUpOf.IsZero() {
+			m.setCatchUpOf(config.CatchUpOf)
+		}
+		list.reporters = append(list.reporters, r)
+		list.filters = append(list.filters, inst.Filter)
+	}
+	return list, nil
+}
+
+func newReporter(commandId, commandName string, options ReporterOptions, config *ReporterConfig) (reporter, error) {
+	labels := config.Labels
+	switch o := options.(type) {
+	case *ConsoleConfig:
+		return newConsoleReporter(commandId, commandName), nil
+	case *FluentdConfig:
+		r, err := newFluentdReporter(commandId, commandName, o, labels)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *FileConfig:
+		r, err := newFileReporter(commandId, commandName, o, labels, config.FileOwner)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *LokiConfig:
+		r, err := newLokiReporter(commandId, commandName, o, labels)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *GelfConfig:
+		r, err := newGelfReporter(commandId, commandName, o, labels)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *JournaldConfig:
+		r, err := newJournaldReporter(commandId, commandName, o, labels)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case *HeartbeatConfig:
+		r, err := newHeartbeatReporter(commandId, commandName, o)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unknown reporter options: %T", options)
+}
+
+// validateReporterConfig fills default names of instances, and rejects instances which
+// would write to the same place.
+func validateReporterConfig(config *ReporterConfig) error {
+	names := make(map[string]bool)
+	fileDirs := make(map[string]string)
+	spoolDirs := make(map[string]string)
+	for _, inst := range config.Reporters {
+		if inst.Options == nil {
+			return fmt.Errorf("options of reporter %s are not specified", inst.Name)
+		}
+		if inst.Name == "" {
+			inst.Name = inst.Options.Type()
+		}
+		if inst.Filter != nil {
+			if err := inst.Filter.Validate(); err != nil {
+				return fmt.Errorf("invalid filter of reporter %s. %s", inst.Name, err)
+			}
+		}
+		if names[inst.Name] {
+			return fmt.Errorf("duplicate reporter name: %s. name reporters of the same type", inst.Name)
+		}
+		names[inst.Name] = true
+
+		switch o := inst.Options.(type) {
+		case *FileConfig:
+			if other, ok := fileDirs[o.Directory]; ok {
+				return fmt.Errorf("reporters %s and %s use the same directory %s", other, inst.Name, o.Directory)
+			}
+			fileDirs[o.Directory] = inst.Name
+		case *FluentdConfig:
+			if o.SpoolDirectory == "" {
+				continue
 			}
-		case "file":
-			r, err := newFileReporter(commandId, commandName, config.FileDirectory)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "failed to initialize file reporter. %s\n", err)
-			} else {
-				list = append(list, r)
+			// spooled events are replayed by any reporter using the directory
+			if other, ok := spoolDirs[o.SpoolDirectory]; ok {
+				return fmt.Errorf("reporters %s and %s use the same spool directory %s", other, inst.Name, o.SpoolDirectory)
 			}
-		default:
-			return list, fmt.Errorf("unknown reporter option: %s", s)
+			spoolDirs[o.SpoolDirectory] = inst.Name
 		}
 	}
-	return list, nil
+	return nil
+}
+
+func (list *ReporterList) CommandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	f := func(r reporter) {
+		r.commandLock(decision, holderPid, at, waited)
+	}
+	list.doForEachReporter(&filterEvent{name: commandLockTag}, f)
 }
 
 func (list *ReporterList) CommandStart(startAt time.Time) {
 	f := func(r reporter) {
 		r.commandStart(startAt)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: commandStartTag}, f)
 }
 
 func (list *ReporterList) CommandSucceed(endAt time.Time, duration time.Duration) {
 	f := func(r reporter) {
 		r.commandSucceed(endAt, duration)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: commandSucceedTag}, f)
 }
 
 func (list *ReporterList) CommandFail(endAt time.Time, duration time.Duration) {
 	f := func(r reporter) {
 		r.commandFail(endAt, duration)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: commandFailTag}, f)
 }
 
 func (list *ReporterList) AttemptStart(count int, pid int, startAt time.Time) {
 	f := func(r reporter) {
 		r.attemptStart(count, pid, startAt)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: attemptStartTag, attempt: count}, f)
+}
+
+func (list *ReporterList) AttemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	f := func(r reporter) {
+		r.attemptSucceed(count, endAt, duration, usage)
+	}
+	// no attempt follows a successful one
+	list.doForEachReporter(&filterEvent{name: attemptSucceedTag, attempt: count, last: true}, f)
+}
+
+func (list *ReporterList) AttemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	f := func(r reporter) {
+		r.attemptFail(count, err, endAt, duration, usage)
+	}
+	list.doForEachReporter(&filterEvent{name: attemptFailTag, attempt: count}, f)
 }
 
-func (list *ReporterList) AttemptSucceed(count int, endAt time.Time, duration time.Duration) {
+func (list *ReporterList) AttemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	f := func(r reporter) {
-		r.attemptSucceed(count, endAt, duration)
+		r.attemptTimeout(count, endAt, duration, usage)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: attemptTimeoutTag, attempt: count}, f)
 }
 
-func (list *ReporterList) AttemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration) {
+func (list *ReporterList) AttemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	f := func(r reporter) {
-		r.attemptFail(count, err, endAt, duration)
+		r.attemptOOM(count, err, endAt, duration, usage)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: attemptOOMTag, attempt: count}, f)
 }
 
-func (list *ReporterList) AttemptTimeout(count int, endAt time.Time, duration time.Duration) {
+func (list *ReporterList) AttemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	f := func(r reporter) {
-		r.attemptTimeout(count, endAt, duration)
+		r.attemptCriteriaFail(count, criterion, endAt, duration, usage)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: attemptCriteriaFailTag, attempt: count}, f)
 }
 
 func (list *ReporterList) AttemptUnknownError(count int, err error, endAt time.Time) {
 	f := func(r reporter) {
 		r.attemptUnknownError(count, err, endAt)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(&filterEvent{name: attemptUnknownErrorTag, attempt: count}, f)
 }
 
 func (list *ReporterList) StartStdoutLogger(count int) {
+	list.stdoutCount = count
 	f := func(r reporter) {
 		r.startStdoutLogger(count)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stdoutEvent(), f)
 }
 
 func (list *ReporterList) FinishStdoutLogger() {
 	f := func(r reporter) {
 		r.finishStdoutLogger()
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stdoutEvent(), f)
 }
 
 func (list *ReporterList) StdoutLog(log string) {
 	f := func(r reporter) {
 		r.stdoutLog(log)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stdoutEvent(), f)
 }
 
 func (list *ReporterList) StartStderrLogger(count int) {
+	list.stderrCount = count
 	f := func(r reporter) {
 		r.startStderrLogger(count)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stderrEvent(), f)
 }
 
 func (list *ReporterList) FinishStderrLogger() {
 	f := func(r reporter) {
 		r.finishStderrLogger()
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stderrEvent(), f)
 }
 
 func (list *ReporterList) StderrLog(log string) {
 	f := func(r reporter) {
 		r.stderrLog(log)
 	}
-	list.doForEachReporter(f)
+	list.doForEachReporter(list.stderrEvent(), f)
+}
+
+func (list *ReporterList) stdoutEvent() *filterEvent {
+	return &filterEvent{name: EventOutput, stream: stdoutTag, attempt: list.stdoutCount}
+}
+
+func (list *ReporterList) stderrEvent() *filterEvent {
+	return &filterEvent{name: EventOutput, stream: stderrTag, attempt: list.stderrCount}
 }
 
 func (list *ReporterList) Close() {
-	for _, r := range []reporter(*list) {
+	// held events are of the last attempt if the command has ended without reporting its end
+	list.doForEachReporter(&filterEvent{}, nil)
+	for _, r := range list.reporters {
 		r.close()
 	}
 }
 
-func (list *ReporterList) doForEachReporter(f func(r reporter)) {
+// doForEachReporter calls f on reporters whose filter matches the event. Events of attempts
+// which may not be the last are held until it turns out. f may be nil to only pass held events.
+func (list *ReporterList) doForEachReporter(e *filterEvent, f func(r reporter)) {
 	var wg sync.WaitGroup
 
-	for _, r := range []reporter(*list) {
+	for i, r := range list.reporters {
+		result := filterAccept
+		if filter := list.filters[i]; filter != nil {
+			result = filter.match(e, list.maxAttempt)
+		}
+		if f == nil {
+			result = filterReject
+		}
+		var fs []func(r reporter)
+		if list.held != nil {
+			fs = list.held.resolve(i, e, result, f)
+		} else if result == filterAccept {
+			fs = []func(r reporter){f}
+		}
+		if len(fs) == 0 {
+			continue
+		}
 		wg.Add(1)
 		go func(r reporter) {
 			defer wg.Done()
-			f(r)
+			for _, f := range fs {
+				f(r)
+			}
 		}(r)
 	}
 	wg.Wait()
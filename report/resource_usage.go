@@ -0,0 +1,85 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResourceUsage is resources used by the process of an attempt and its descendants which
+// it has waited for. Times are in seconds, and sizes are in bytes.
+type ResourceUsage struct {
+	UserTime                   float64 `json:"userTime"`
+	SystemTime                 float64 `json:"systemTime"`
+	MaxRSS                     int64   `json:"maxRss"`
+	VoluntaryContextSwitches   int64   `json:"voluntaryContextSwitches"`
+	InvoluntaryContextSwitches int64   `json:"involuntaryContextSwitches"`
+	// BlockInputs and BlockOutputs are numbers of block I/O operations.
+	BlockInputs  int64 `json:"blockInputs"`
+	BlockOutputs int64 `json:"blockOutputs"`
+	// Cgroup is nil if the attempt has not run in a cgroup.
+	Cgroup *CgroupUsage `json:"cgroup,omitempty"`
+}
+
+// CgroupUsage is resources used by all the processes in the cgroup of an attempt, including
+// the ones which have not been waited for.
+type CgroupUsage struct {
+	CPUTime float64 `json:"cpuTime"`
+	// MemoryPeak is 0 if the kernel does not report it.
+	MemoryPeak int64 `json:"memoryPeak,omitempty"`
+	// IOReadBytes and IOWriteBytes are 0 if the io controller is not available.
+	IOReadBytes  int64 `json:"ioReadBytes,omitempty"`
+	IOWriteBytes int64 `json:"ioWriteBytes,omitempty"`
+}
+
+// fields returns the usage as flat fields of a record with camelCase keys.
+func (u *ResourceUsage) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"userTime":                   u.UserTime,
+		"systemTime":                 u.SystemTime,
+		"maxRss":                     u.MaxRSS,
+		"voluntaryContextSwitches":   u.VoluntaryContextSwitches,
+		"involuntaryContextSwitches": u.InvoluntaryContextSwitches,
+		"blockInputs":                u.BlockInputs,
+		"blockOutputs":               u.BlockOutputs,
+	}
+	if c := u.Cgroup; c != nil {
+		fields["cgroupCpuTime"] = c.CPUTime
+		if c.MemoryPeak > 0 {
+			fields["cgroupMemoryPeak"] = c.MemoryPeak
+		}
+		if c.IOReadBytes > 0 || c.IOWriteBytes > 0 {
+			fields["cgroupIoReadBytes"] = c.IOReadBytes
+			fields["cgroupIoWriteBytes"] = c.IOWriteBytes
+		}
+	}
+	return fields
+}
+
+func (u *ResourceUsage) String() string {
+	s := fmt.Sprintf("user: %f seconds, system: %f seconds, max rss: %s, context switches: %d voluntary %d involuntary, block i/o: %d in %d out",
+		u.UserTime, u.SystemTime, formatBytes(u.MaxRSS), u.VoluntaryContextSwitches, u.InvoluntaryContextSwitches, u.BlockInputs, u.BlockOutputs)
+	if c := u.Cgroup; c != nil {
+		s += fmt.Sprintf(", cgroup cpu: %f seconds", c.CPUTime)
+		if c.MemoryPeak > 0 {
+			s += fmt.Sprintf(", cgroup memory peak: %s", formatBytes(c.MemoryPeak))
+		}
+		if c.IOReadBytes > 0 || c.IOWriteBytes > 0 {
+			s += fmt.Sprintf(", cgroup i/o: %s read %s written", formatBytes(c.IOReadBytes), formatBytes(c.IOWriteBytes))
+		}
+	}
+	return s
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", f), "0"), ".") + units[i]
+}
@@ -0,0 +1,80 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResourceUsageFields(t *testing.T) {
+	base := func() *ResourceUsage {
+		return &ResourceUsage{
+			UserTime:                   1.5,
+			SystemTime:                 0.25,
+			MaxRSS:                     2048,
+			VoluntaryContextSwitches:   3,
+			InvoluntaryContextSwitches: 4,
+			BlockInputs:                5,
+			BlockOutputs:               6,
+		}
+	}
+	baseFields := func() map[string]interface{} {
+		return map[string]interface{}{
+			"userTime":                   1.5,
+			"systemTime":                 0.25,
+			"maxRss":                     int64(2048),
+			"voluntaryContextSwitches":   int64(3),
+			"involuntaryContextSwitches": int64(4),
+			"blockInputs":                int64(5),
+			"blockOutputs":               int64(6),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		cgroup *CgroupUsage
+		fields map[string]interface{}
+		str    string
+	}{
+		{"rusage only", nil, map[string]interface{}{},
+			"user: 1.500000 seconds, system: 0.250000 seconds, max rss: 2KB, context switches: 3 voluntary 4 involuntary, block i/o: 5 in 6 out"},
+		{"cgroup cpu only", &CgroupUsage{CPUTime: 2}, map[string]interface{}{"cgroupCpuTime": 2.0},
+			"user: 1.500000 seconds, system: 0.250000 seconds, max rss: 2KB, context switches: 3 voluntary 4 involuntary, block i/o: 5 in 6 out, cgroup cpu: 2.000000 seconds"},
+		{"cgroup", &CgroupUsage{CPUTime: 2, MemoryPeak: 3 << 20, IOReadBytes: 1536, IOWriteBytes: 0},
+			map[string]interface{}{"cgroupCpuTime": 2.0, "cgroupMemoryPeak": int64(3 << 20), "cgroupIoReadBytes": int64(1536), "cgroupIoWriteBytes": int64(0)},
+			"user: 1.500000 seconds, system: 0.250000 seconds, max rss: 2KB, context switches: 3 voluntary 4 involuntary, block i/o: 5 in 6 out, cgroup cpu: 2.000000 seconds, cgroup memory peak: 3MB, cgroup i/o: 1.5KB read 0B written"},
+	}
+	for _, tt := range tests {
+		u := base()
+		u.Cgroup = tt.cgroup
+		want := baseFields()
+		for k, v := range tt.fields {
+			want[k] = v
+		}
+		if fields := u.fields(); !reflect.DeepEqual(fields, want) {
+			t.Errorf("%s: got fields %v, want %v", tt.name, fields, want)
+		}
+		if s := u.String(); s != tt.str {
+			t.Errorf("%s: got %q, want %q", tt.name, s, tt.str)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1KB"},
+		{1536, "1.5KB"},
+		{5 << 30, "5GB"},
+		{3 << 40, "3TB"},
+		{2048 << 40, "2048TB"},
+	}
+	for _, tt := range tests {
+		if s := formatBytes(tt.n); s != tt.want {
+			t.Errorf("formatBytes(%d) = %s, want %s", tt.n, s, tt.want)
+		}
+	}
+}
@@ -13,9 +13,44 @@ type stringReporter struct {
 	fh          io.Writer
 	out         io.Writer
 	err         io.Writer
+	catchUpOf   time.Time
+}
+
+// catchUpMarker is a reporter which marks a catch-up run in its messages.
+type catchUpMarker interface {
+	setCatchUpOf(scheduledAt time.Time)
+}
+
+func (r *stringReporter) setCatchUpOf(scheduledAt time.Time) {
+	r.catchUpOf = scheduledAt
+}
+
+// catchUpNote describes a catch-up run, or is empty for other runs.
+func (r *stringReporter) catchUpNote() string {
+	if r.catchUpOf.IsZero() {
+		return ""
+	}
+	return "catch-up of the run scheduled at " + r.catchUpOf.Format(time.RFC3339)
+}
+
+func (r *stringReporter) commandLock(decision string, holderPid int, at time.Time, waited time.Duration) {
+	switch decision {
+	case LockSkipped:
+		r.write(at, "The command has been skipped due to running instance. pid: %d\n", holderPid)
+	case LockWaited:
+		r.write(at, "The command has waited for running instance in %f seconds. pid: %d\n", waited.Seconds(), holderPid)
+	case LockWaitTimeout:
+		r.write(at, "The command has been skipped since running instance has not finished in %f seconds. pid: %d\n", waited.Seconds(), holderPid)
+	case LockKilled:
+		r.write(at, "The command has killed running instance in %f seconds. pid: %d\n", waited.Seconds(), holderPid)
+	}
 }
 
 func (r *stringReporter) commandStart(startAt time.Time) {
+	if note := r.catchUpNote(); note != "" {
+		r.write(startAt, "The command has started as a %s\n", note)
+		return
+	}
 	r.write(startAt, "The command has started\n")
 }
 
@@ -31,22 +66,41 @@ func (r *stringReporter) attemptStart(count int, pid int, startAt time.Time) {
 	r.write(startAt, "The %s attempt has started. pid: %d\n", ordinalize(count), pid)
 }
 
-func (r *stringReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration) {
+func (r *stringReporter) attemptSucceed(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	r.write(endAt, "The %s attempt has finished with success in %f seconds.\n", ordinalize(count), duration.Seconds())
+	r.writeUsage(count, endAt, usage)
 }
 
-func (r *stringReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration) {
+func (r *stringReporter) attemptFail(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	r.write(endAt, "The %s attempt has failed in %f seconds.: %s.\n", ordinalize(count), duration.Seconds(), err)
+	r.writeUsage(count, endAt, usage)
 }
 
-func (r *stringReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration) {
+func (r *stringReporter) attemptTimeout(count int, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
 	r.write(endAt, "The %s attempt has been killed due to timeout. %f seconds has beed exceeded.\n", ordinalize(count), duration.Seconds())
+	r.writeUsage(count, endAt, usage)
+}
+
+func (r *stringReporter) attemptOOM(count int, err *exec.ExitError, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.write(endAt, "The %s attempt has been killed due to out of memory in %f seconds.: %s.\n", ordinalize(count), duration.Seconds(), err)
+	r.writeUsage(count, endAt, usage)
+}
+
+func (r *stringReporter) attemptCriteriaFail(count int, criterion string, endAt time.Time, duration time.Duration, usage *ResourceUsage) {
+	r.write(endAt, "The %s attempt has not met the success criteria in %f seconds.: %s.\n", ordinalize(count), duration.Seconds(), criterion)
+	r.writeUsage(count, endAt, usage)
 }
 
 func (r *stringReporter) attemptUnknownError(count int, err error, endAt time.Time) {
 	r.write(endAt, "The %s attempt has failed with unknown error.: %s.\n", ordinalize(count), err)
 }
 
+func (r *stringReporter) writeUsage(count int, endAt time.Time, usage *ResourceUsage) {
+	if usage != nil {
+		r.write(endAt, "The %s attempt has used resources. %s\n", ordinalize(count), usage)
+	}
+}
+
 func (r *stringReporter) stdoutLog(log string) {
 	if r.out != nil {
 		fmt.Fprint(r.out, log)
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/choplin/go-job/report"
+	"gopkg.in/yaml.v3"
+)
+
+// configValue is a value in a job file. It is one of string, []*configValue and
+// *configMap. Line is 0 when the format does not provide positions.
+type configValue struct {
+	value interface{}
+	line  int
+}
+
+// configMap keeps keys in the order of the file so that errors are reported in order.
+type configMap struct {
+	keys   []string
+	values map[string]*configValue
+}
+
+// configError points to the key which has an invalid value.
+type configError struct {
+	file string
+	line int
+	key  string
+	msg  string
+}
+
+func (e *configError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", e.file, e.line, e.key, e.msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.file, e.key, e.msg)
+}
+
+func parseJobFile(path string) (*configValue, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == ".toml" {
+		var m map[string]interface{}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		return fromTOML(m), nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return &configValue{&configMap{values: map[string]*configValue{}}, 0}, nil
+	}
+	return fromYAML(doc.Content[0])
+}
+
+func fromYAML(node *yaml.Node) (*configValue, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return fromYAML(node.Alias)
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return &configValue{"", node.Line}, nil
+		}
+		return &configValue{node.Value, node.Line}, nil
+	case yaml.SequenceNode:
+		list := make([]*configValue, 0, len(node.Content))
+		for _, n := range node.Content {
+			v, err := fromYAML(n)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return &configValue{list, node.Line}, nil
+	case yaml.MappingNode:
+		m := &configMap{values: make(map[string]*configValue)}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			value, err := fromYAML(v)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := m.values[k.Value]; !ok {
+				m.keys = append(m.keys, k.Value)
+			}
+			// the line of a key is more helpful than the line of its value
+			value.line = k.Line
+			m.values[k.Value] = value
+		}
+		return &configValue{m, node.Line}, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported yaml node", node.Line)
+}
+
+func fromTOML(v interface{}) *configValue {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := &configMap{values: make(map[string]*configValue)}
+		for k, e := range t {
+			m.keys = append(m.keys, k)
+			m.values[k] = fromTOML(e)
+		}
+		sort.Strings(m.keys)
+		return &configValue{m, 0}
+	case []map[string]interface{}:
+		list := make([]*configValue, 0, len(t))
+		for _, e := range t {
+			list = append(list, fromTOML(e))
+		}
+		return &configValue{list, 0}
+	case []interface{}:
+		list := make([]*configValue, 0, len(t))
+		for _, e := range t {
+			list = append(list, fromTOML(e))
+		}
+		return &configValue{list, 0}
+	}
+	return &configValue{fmt.Sprint(v), 0}
+}
+
+// jobFile applies a job file to flags, and builds reporters defined in it. Flags which have
+// been set on the command line are not overwritten. Options of a reporter are the flags of
+// its type without prefix, e.g. keep_runs of a file reporter is -file-keep-runs.
+type jobFile struct {
+	path string
+	// prefix is prepended to keys in errors when the job is nested in another file.
+	prefix   string
+	fs       *flag.FlagSet
+	options  *jobOptions
+	explicit map[string]bool
+	// skip are keys handled by the caller.
+	skip map[string]bool
+
+	command   []string
+	reporters []*report.ReporterInstance
+}
+
+func (j *jobFile) error(v *configValue, key string, format string, args ...interface{}) error {
+	return &configError{j.path, v.line, j.prefix + key, fmt.Sprintf(format, args...)}
+}
+
+func (j *jobFile) scalar(v *configValue, key string) (string, error) {
+	s, ok := v.value.(string)
+	if !ok {
+		return "", j.error(v, key, "must be a scalar value")
+	}
+	return s, nil
+}
+
+// scalarOrList accepts a list of scalars as comma separated values.
+func (j *jobFile) scalarOrList(v *configValue, key string) (string, error) {
+	list, ok := v.value.([]*configValue)
+	if !ok {
+		return j.scalar(v, key)
+	}
+	values := make([]string, 0, len(list))
+	for i, e := range list {
+		s, err := j.scalar(e, fmt.Sprintf("%s[%d]", key, i))
+		if err != nil {
+			return "", err
+		}
+		values = append(values, s)
+	}
+	return strings.Join(values, ","), nil
+}
+
+func (j *jobFile) set(v *configValue, key string, name string) error {
+	s, err := j.scalar(v, key)
+	if err != nil {
+		return err
+	}
+	if j.explicit[name] {
+		return nil
+	}
+	if err := j.fs.Set(name, s); err != nil {
+		return j.error(v, key, "invalid value %q. %s", s, err)
+	}
+	return nil
+}
+
+// setList sets a flag of comma separated values from a list or a scalar.
+func (j *jobFile) setList(v *configValue, key string, name string) error {
+	s, err := j.scalarOrList(v, key)
+	if err != nil {
+		return err
+	}
+	if j.explicit[name] {
+		return nil
+	}
+	if err := j.fs.Set(name, s); err != nil {
+		return j.error(v, key, "invalid value %q. %s", s, err)
+	}
+	return nil
+}
+
+// setEach sets a repeatable flag with each value of a list or a scalar.
+func (j *jobFile) setEach(v *configValue, key string, name string) error {
+	list, ok := v.value.([]*configValue)
+	if !ok {
+		list = []*configValue{v}
+	}
+	if j.explicit[name] {
+		return nil
+	}
+	for i, e := range list {
+		k := key
+		if e != v {
+			k = fmt.Sprintf("%s[%d]", key, i)
+		}
+		s, err := j.scalar(e, k)
+		if err != nil {
+			return err
+		}
+		if err := j.fs.Set(name, s); err != nil {
+			return j.error(e, k, "invalid value %q. %s", s, err)
+		}
+	}
+	return nil
+}
+
+// setKeyValues adds pairs to a keyValues flag. Keys given on the command line take precedence.
+func (j *jobFile) setKeyValues(v *configValue, key string, kv keyValues) error {
+	m, ok := v.value.(*configMap)
+	if !ok {
+		return j.error(v, key, "must be a map")
+	}
+	for _, k := range m.keys {
+		s, err := j.scalar(m.values[k], key+"."+k)
+		if err != nil {
+			return err
+		}
+		if _, ok := kv[k]; !ok {
+			kv[k] = s
+		}
+	}
+	return nil
+}
+
+func (j *jobFile) apply(root *configValue) error {
+	m, ok := root.value.(*configMap)
+	if !ok {
+		return j.error(root, "(root)", "must be a map")
+	}
+
+	var command []string
+	for _, key := range m.keys {
+		if j.skip[key] {
+			continue
+		}
+		v := m.values[key]
+		var err error
+		switch key {
+		case "name":
+			err = j.set(v, key, "name")
+		case "command":
+			var s string
+			if s, err = j.scalar(v, key); err == nil {
+				command = append([]string{s}, command...)
+			}
+		case "args":
+			list, ok := v.value.([]*configValue)
+			if !ok {
+				return j.error(v, key, "must be a list")
+			}
+			for i, e := range list {
+				s, err := j.scalar(e, fmt.Sprintf("args[%d]", i))
+				if err != nil {
+					return err
+				}
+				command = append(command, s)
+			}
+		case "timeout":
+			err = j.set(v, key, "timeout")
+		case "attempts":
+			err = j.set(v, key, "attempt")
+		case "cwd":
+			err = j.set(v, key, "cwd")
+		case "lock":
+			err = j.set(v, key, "lock")
+		case "lock_directory":
+			err = j.set(v, key, "lock-directory")
+		case "lock_timeout":
+			err = j.set(v, key, "lock-timeout")
+		case "shell":
+			err = j.set(v, key, "shell")
+		case "shell_path":
+			err = j.set(v, key, "shell-path")
+		case "template":
+			err = j.set(v, key, "template")
+		case "user":
+			err = j.set(v, key, "user")
+		case "group":
+			err = j.set(v, key, "group")
+		case "groups":
+			err = j.setList(v, key, "groups")
+		case "reset_user_env":
+			err = j.set(v, key, "reset-user-env")
+		case "rlimit_as", "rlimit_nofile", "rlimit_cpu", "rlimit_core",
+			"cgroup_memory_max", "cgroup_cpu_max", "cgroup_pids_max", "cgroup_directory":
+			err = j.set(v, key, strings.Replace(key, "_", "-", -1))
+		case "success_exit_codes":
+			err = j.setList(v, key, "success-exit-codes")
+		case "stdout_must_match", "stdout_must_not_match", "stderr_must_match", "stderr_must_not_match":
+			err = j.setEach(v, key, strings.Replace(key, "_", "-", -1))
+		case "post_check", "post_check_timeout":
+			err = j.set(v, key, strings.Replace(key, "_", "-", -1))
+		case "env":
+			err = j.setKeyValues(v, key, j.options.env)
+		case "labels":
+			err = j.setKeyValues(v, key, j.options.labels)
+		case "reporters":
+			err = j.applyReporters(v)
+		default:
+			err = j.error(v, key, "unknown key")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, ok := m.values["command"]; !ok && len(command) > 0 {
+		return j.error(m.values["args"], "args", "command must be specified with args")
+	}
+	j.command = command
+	return nil
+}
+
+func (j *jobFile) applyReporters(v *configValue) error {
+	list, ok := v.value.([]*configValue)
+	if !ok {
+		return j.error(v, "reporters", "must be a list")
+	}
+
+	// the number of reporters of each type, which decides whether options of the type given
+	// on the command line can be applied
+	counts := make(map[string]int)
+	for _, e := range list {
+		if m, ok := e.value.(*configMap); ok {
+			if tv, ok := m.values["type"]; ok {
+				if typ, ok := tv.value.(string); ok {
+					counts[typ]++
+				}
+			}
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, e := range list {
+		key := fmt.Sprintf("reporters[%d]", i)
+		m, ok := e.value.(*configMap)
+		if !ok {
+			return j.error(e, key, "must be a map")
+		}
+		tv, ok := m.values["type"]
+		if !ok {
+			return j.error(e, key, "type must be specified")
+		}
+		typ, err := j.scalar(tv, key+".type")
+		if err != nil {
+			return err
+		}
+		fs := flag.NewFlagSet(key, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		b := registerReporterFlags(fs, typ, "")
+		if b == nil {
+			return j.error(tv, key+".type", "unknown reporter: %s", typ)
+		}
+
+		name := typ
+		if nv, ok := m.values["name"]; ok {
+			if name, err = j.scalar(nv, key+".name"); err != nil {
+				return err
+			}
+		}
+		if names[name] {
+			return j.error(e, key, "reporter %s is defined more than once. give each reporter a unique name", name)
+		}
+		names[name] = true
+
+		for _, k := range m.keys {
+			if k == "type" || k == "name" {
+				continue
+			}
+			s, err := j.scalarOrList(m.values[k], key+"."+k)
+			if err != nil {
+				return err
+			}
+			opt := strings.Replace(k, "_", "-", -1)
+			if fs.Lookup(opt) == nil {
+				return j.error(m.values[k], key+"."+k, "unknown option of %s reporter", typ)
+			}
+			if err := fs.Set(opt, s); err != nil {
+				return j.error(m.values[k], key+"."+k, "invalid value %q. %s", s, err)
+			}
+		}
+
+		// options of the type given on the command line override the reporter only if it is
+		// the only one of the type, since it is unclear which reporter they are meant for
+		prefix := typ + "-"
+		for name := range j.explicit {
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if counts[typ] > 1 {
+				return j.error(e, key, "-%s is ambiguous since %d %s reporters are defined. set the option of each reporter in the job file", name, counts[typ], typ)
+			}
+			fs.Set(strings.TrimPrefix(name, prefix), flag.Lookup(name).Value.String())
+		}
+
+		inst, err := b.build(name)
+		if err != nil {
+			return j.error(e, key, "%s", err)
+		}
+		j.reporters = append(j.reporters, inst)
+	}
+	return nil
+}
+
+// isFlagSet reports whether the flag has been given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadJobFile applies a job file to flags, and returns the command, its arguments and
+// reporters defined in it.
+func loadJobFile(path string) (*jobFile, error) {
+	root, err := parseJobFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	j := &jobFile{path: path, fs: flag.CommandLine, options: options, explicit: explicit}
+	if err := j.apply(root); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
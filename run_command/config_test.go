@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+// loadTestJob applies a job file to the flags as loadJobFile does, and restores them at the
+// end of the test.
+func loadTestJob(t *testing.T, name string, content string, explicit map[string]bool) (*jobFile, error) {
+	saved := make(map[string]string)
+	for _, f := range []string{"name", "timeout", "attempt", "cwd"} {
+		saved[f] = flag.Lookup(f).Value.String()
+	}
+	env := options.env
+	options.env = keyValues{}
+	for k, v := range env {
+		options.env[k] = v
+	}
+	t.Cleanup(func() {
+		for f, v := range saved {
+			flag.Set(f, v)
+		}
+		options.env = env
+	})
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseJobFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &jobFile{path: path, fs: flag.CommandLine, options: options, explicit: explicit}
+	return j, j.apply(root)
+}
+
+func TestLoadJobFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "job.yaml", `
+name: backup
+command: /bin/echo
+args: [hello, world]
+timeout: 1m
+attempts: 3
+cwd: /tmp
+env:
+  FOO: bar
+`},
+		{"toml", "job.toml", `
+name = "backup"
+command = "/bin/echo"
+args = ["hello", "world"]
+timeout = "1m"
+attempts = 3
+cwd = "/tmp"
+
+[env]
+FOO = "bar"
+`},
+	}
+	for _, tt := range tests {
+		j, err := loadTestJob(t, tt.file, tt.content, nil)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if want := []string{"/bin/echo", "hello", "world"}; !reflect.DeepEqual(j.command, want) {
+			t.Errorf("%s: got command %v, want %v", tt.name, j.command, want)
+		}
+		if *options.name != "backup" || *options.timeout != time.Minute || *options.attempt != 3 || *options.cwd != "/tmp" {
+			t.Errorf("%s: got name %s, timeout %s, attempt %d, cwd %s", tt.name, *options.name, *options.timeout, *options.attempt, *options.cwd)
+		}
+		if want := (keyValues{"FOO": "bar"}); !reflect.DeepEqual(options.env, want) {
+			t.Errorf("%s: got env %v, want %v", tt.name, options.env, want)
+		}
+	}
+}
+
+func TestLoadJobFileOverride(t *testing.T) {
+	content := "command: /bin/true\ntimeout: 1m\nattempts: 3\nenv:\n  FOO: bar\n  BAZ: qux\n"
+	// values given on the command line
+	old := flag.Lookup("timeout").Value.String()
+	env := options.env
+	t.Cleanup(func() {
+		flag.Set("timeout", old)
+		options.env = env
+	})
+	flag.Set("timeout", "5s")
+	options.env = keyValues{"FOO": "flag"}
+	if _, err := loadTestJob(t, "job.yaml", content, map[string]bool{"timeout": true}); err != nil {
+		t.Fatal(err)
+	}
+	if *options.timeout != 5*time.Second {
+		t.Errorf("got timeout %s, want 5s given by the flag", *options.timeout)
+	}
+	if *options.attempt != 3 {
+		t.Errorf("got attempt %d, want 3 of the file", *options.attempt)
+	}
+	if want := (keyValues{"FOO": "flag", "BAZ": "qux"}); !reflect.DeepEqual(options.env, want) {
+		t.Errorf("got env %v, want %v", options.env, want)
+	}
+}
+
+func TestLoadJobFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"not a map", "- a\n", ":1: (root): must be a map"},
+		{"unknown key", "command: a\nfoo: 1\n", ":2: foo: unknown key"},
+		{"invalid value", "command: a\ntimeout: soon\n", `:2: timeout: invalid value "soon". parse error`},
+		{"not a scalar", "command: a\nattempts: [1]\n", ":2: attempts: must be a scalar value"},
+		{"args not a list", "command: a\nargs: b\n", ":2: args: must be a list"},
+		{"args of a map", "command: a\nargs:\n  - b\n  - {c: d}\n", ":4: args[1]: must be a scalar value"},
+		{"args without command", "args: [b]\n", ":1: args: command must be specified with args"},
+		{"env not a map", "command: a\nenv: FOO\n", ":2: env: must be a map"},
+		{"env value", "command: a\nenv:\n  FOO: [bar]\n", ":3: env.FOO: must be a scalar value"},
+	}
+	for _, tt := range tests {
+		j, err := loadTestJob(t, "job.yaml", tt.content, nil)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if want := j.path + tt.want; err.Error() != want {
+			t.Errorf("%s: got %q, want %q", tt.name, err, want)
+		}
+	}
+}
+
+func loadTestReporters(t *testing.T, content string, explicit map[string]bool) (*jobFile, error) {
+	path := filepath.Join(t.TempDir(), "job.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root, err := parseJobFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &jobFile{path: path, explicit: explicit}
+	return j, j.applyReporters(root.value.(*configMap).values["reporters"])
+}
+
+func TestApplyReportersErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		explicit map[string]bool
+		want     string
+	}{
+		{"not a list", "reporters: file\n", nil, ":1: reporters: must be a list"},
+		{"not a map", "reporters:\n  - file\n", nil, ":2: reporters[0]: must be a map"},
+		{"no type", "reporters:\n  - name: a\n", nil, ":2: reporters[0]: type must be specified"},
+		{"unknown type", "reporters:\n  - type: foo\n", nil, ":2: reporters[0].type: unknown reporter: foo"},
+		{"unknown option", "reporters:\n  - type: file\n    foo: 1\n", nil, ":3: reporters[0].foo: unknown option of file reporter"},
+		{"duplicated name", "reporters:\n  - type: file\n  - type: file\n", nil,
+			":3: reporters[1]: reporter file is defined more than once. give each reporter a unique name"},
+		{"ambiguous override", "reporters:\n  - type: file\n    name: a\n  - type: file\n    name: b\n", map[string]bool{"file-directory": true},
+			":2: reporters[0]: -file-directory is ambiguous since 2 file reporters are defined. set the option of each reporter in the job file"},
+	}
+	for _, tt := range tests {
+		j, err := loadTestReporters(t, tt.content, tt.explicit)
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+			continue
+		}
+		if want := j.path + tt.want; err.Error() != want {
+			t.Errorf("%s: got %q, want %q", tt.name, err, want)
+		}
+	}
+}
+
+func TestApplyReportersOverride(t *testing.T) {
+	old := flag.Lookup("file-directory").Value.String()
+	defer flag.Set("file-directory", old)
+	flag.Set("file-directory", "/override")
+
+	content := "reporters:\n  - type: file\n    directory: /file\n  - type: console\n"
+	j, err := loadTestReporters(t, content, map[string]bool{"file-directory": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := j.reporters[0].Options.(*report.FileConfig).Directory; dir != "/override" {
+		t.Errorf("got directory %s, want /override", dir)
+	}
+}
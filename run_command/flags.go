@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/choplin/go-job/command"
+)
+
+// byteSize is a flag value of a size in bytes with an optional unit such as "100MB".
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"TB", 1 << 40},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+	{"B", 1},
+}
+
+func parseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(n)
+	return nil
+}
+
+// optionalByteSize is a byteSize which distinguishes 0 from not given.
+type optionalByteSize struct {
+	set  bool
+	size byteSize
+}
+
+func (b *optionalByteSize) String() string {
+	if !b.set {
+		return ""
+	}
+	return b.size.String()
+}
+
+func (b *optionalByteSize) Set(s string) error {
+	if err := b.size.Set(s); err != nil {
+		return err
+	}
+	b.set = true
+	return nil
+}
+
+// keyValues is a repeatable flag of key=value pairs.
+type keyValues map[string]string
+
+func (l keyValues) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l keyValues) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("must be key=value: %s", s)
+	}
+	l[kv[0]] = kv[1]
+	return nil
+}
+
+// stringList is a flag of comma separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*l = append(*l, e)
+		}
+	}
+	return nil
+}
+
+// intList is a flag of comma separated integers.
+type intList []int
+
+func (l *intList) String() string {
+	s := make([]string, 0, len(*l))
+	for _, n := range *l {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *intList) Set(s string) error {
+	*l = nil
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		n, err := strconv.Atoi(e)
+		if err != nil {
+			return fmt.Errorf("invalid integer: %s", e)
+		}
+		*l = append(*l, n)
+	}
+	return nil
+}
+
+// regexpList is a repeatable flag of regular expressions.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	s := make([]string, 0, len(*l))
+	for _, r := range *l {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *regexpList) Set(s string) error {
+	r, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+// lockPolicy is a flag of a lock policy. An empty value disables the lock.
+type lockPolicy string
+
+func (p *lockPolicy) String() string {
+	return string(*p)
+}
+
+func (p *lockPolicy) Set(s string) error {
+	switch s {
+	case "":
+		*p = lockPolicy(s)
+		return nil
+	case command.LockSkip, command.LockWait, command.LockKill:
+		if !command.LockSupported {
+			return fmt.Errorf("locks are not supported on this platform")
+		}
+		*p = lockPolicy(s)
+		return nil
+	}
+	return fmt.Errorf("unknown lock policy: %s", s)
+}
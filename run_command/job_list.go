@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/choplin/go-job/report"
+)
+
+// jobList is a file which defines multiple jobs under jobs, such as a schedule file. Each job
+// has the keys of a job file, and top-level labels and reporters are defaults of all the jobs.
+type jobList struct {
+	top *jobFile
+	// values are the top-level values including the ones specific to the file.
+	values    *configMap
+	labels    keyValues
+	reporters []*report.ReporterInstance
+	entries   []*jobEntry
+}
+
+type jobEntry struct {
+	file *jobFile
+	// values are the values of the job including the ones specific to the file.
+	values *configMap
+	value  *configValue
+}
+
+func (e *jobEntry) name() string {
+	return *e.file.options.name
+}
+
+// loadJobList loads a file of jobs. topKeys and jobKeys are keys specific to the file, which
+// are left to the caller.
+func loadJobList(file string, topKeys []string, jobKeys []string) (*jobList, error) {
+	root, err := parseJobFile(file)
+	if err != nil {
+		return nil, err
+	}
+	top := &jobFile{path: file}
+	m, ok := root.value.(*configMap)
+	if !ok {
+		return nil, top.error(root, "(root)", "must be a map")
+	}
+
+	labels := keyValues{}
+	var reporters []*report.ReporterInstance
+	var jobs *configValue
+	for _, key := range m.keys {
+		v := m.values[key]
+		var err error
+		switch key {
+		case "labels":
+			err = top.setKeyValues(v, key, labels)
+		case "reporters":
+			if err = top.applyReporters(v); err == nil {
+				reporters = top.reporters
+			}
+		case "jobs":
+			jobs = v
+		default:
+			if !containsString(topKeys, key) {
+				err = top.error(v, key, "unknown key")
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if jobs == nil {
+		return nil, top.error(root, "jobs", "must be specified")
+	}
+	list, ok := jobs.value.([]*configValue)
+	if !ok {
+		return nil, top.error(jobs, "jobs", "must be a list")
+	}
+
+	skip := make(map[string]bool)
+	for _, k := range jobKeys {
+		skip[k] = true
+	}
+	ret := &jobList{top: top, values: m, labels: labels, reporters: reporters}
+	names := make(map[string]bool)
+	for i, v := range list {
+		key := fmt.Sprintf("jobs[%d]", i)
+		jm, ok := v.value.(*configMap)
+		if !ok {
+			return nil, top.error(v, key, "must be a map")
+		}
+		fs := flag.NewFlagSet(key, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		j := &jobFile{
+			path:    file,
+			prefix:  key + ".",
+			fs:      fs,
+			options: registerJobFlags(fs),
+			skip:    skip,
+		}
+		if err := j.apply(v); err != nil {
+			return nil, err
+		}
+		if len(j.command) == 0 {
+			return nil, j.error(v, "command", "must be specified")
+		}
+		if err := j.options.lookupCredential(); err != nil {
+			return nil, top.error(v, key, "failed to find the user. %s", err)
+		}
+		if err := j.options.validateTemplates(j.command); err != nil {
+			return nil, j.error(v, "command", "%s", err)
+		}
+		e := &jobEntry{file: j, values: jm, value: v}
+		if e.name() == "" {
+			*j.options.name = j.options.defaultName(j.command)
+		}
+		if names[e.name()] {
+			return nil, top.error(v, key, "job %s is defined more than once. give each job a unique name", e.name())
+		}
+		names[e.name()] = true
+
+		for k, v := range labels {
+			if _, ok := j.options.labels[k]; !ok {
+				j.options.labels[k] = v
+			}
+		}
+		if j.reporters == nil {
+			j.reporters = reporters
+		}
+		if j.reporters == nil {
+			j.reporters = []*report.ReporterInstance{{Name: report.ReporterConsole, Options: &report.ConsoleConfig{}}}
+		}
+		ret.entries = append(ret.entries, e)
+	}
+	return ret, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
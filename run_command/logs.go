@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+const followInterval = 500 * time.Millisecond
+
+type logsOptions struct {
+	attempt   int
+	stdout    bool
+	stderr    bool
+	combined  bool
+	lifecycle bool
+	follow    bool
+}
+
+func logsUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s logs [options] name [id]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Show logs of a run stored by the file reporter. The latest run is shown if id is omitted.\n")
+		fs.PrintDefaults()
+	}
+}
+
+func runLogs(args []string) int {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	fs.Usage = logsUsage(fs)
+	directory := fs.String("file-directory", defaultFileDirectory, "a base directory of file reporter.")
+	attempt := fs.Int("attempt", 0, "show only the given attempt. 0 means all attempts.")
+	stream := fs.String("stream", "all", "output streams to show. available: stdout, stderr, all, combined, none. combined requires -file-combined on the run. all shows the combined log if the run has it, where stdout and stderr are interleaved, or stdout and then stderr.")
+	lifecycle := fs.Bool("lifecycle", true, "show lifecycle events recorded in command.log.")
+	follow := fs.Bool("f", false, "keep reading a run in progress until the command finishes.")
+	list := fs.Bool("list", false, "list runs of the command with their status instead of showing logs.")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return 1
+	}
+	commandName := fs.Arg(0)
+
+	if *list {
+		if err := listRuns(os.Stdout, *directory, commandName); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list runs. %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	opts := &logsOptions{attempt: *attempt, lifecycle: *lifecycle, follow: *follow}
+	switch *stream {
+	case "all":
+		opts.stdout, opts.stderr = true, true
+	case "stdout":
+		opts.stdout = true
+	case "stderr":
+		opts.stderr = true
+	case "combined":
+		opts.combined = true
+	case "none":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown stream: %s\n", *stream)
+		return 1
+	}
+
+	run, err := report.FindFileRun(*directory, commandName, fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find a run. %s\n", err)
+		return 1
+	}
+
+	if err := showRun(os.Stdout, run, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to show logs. %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func listRuns(w io.Writer, directory string, commandName string) error {
+	runs, err := report.FileRuns(directory, commandName)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		status, err := run.Status()
+		if err != nil {
+			status = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", run.CommandId, status)
+	}
+	return nil
+}
+
+// tailer reads a file incrementally. The file does not need to exist yet.
+type tailer struct {
+	path    string
+	fh      io.ReadCloser
+	reader  *bufio.Reader
+	partial string
+}
+
+func (t *tailer) open() bool {
+	if t.fh != nil {
+		return true
+	}
+	fh, err := report.OpenOutputLog(t.path)
+	if err != nil {
+		return false
+	}
+	t.fh = fh
+	t.reader = bufio.NewReader(fh)
+	return true
+}
+
+// readLine returns a complete line including the trailing newline. A partial line is kept
+// for the next call unless flush is true.
+func (t *tailer) readLine(flush bool) (string, bool) {
+	if !t.open() {
+		return "", false
+	}
+	line, err := t.reader.ReadString('\n')
+	line = t.partial + line
+	t.partial = ""
+	if err == nil || (flush && line != "") {
+		return line, true
+	}
+	t.partial = line
+	return "", false
+}
+
+func (t *tailer) close() {
+	if t.fh != nil {
+		t.fh.Close()
+	}
+}
+
+// logPrinter prints lifecycle events and output in the order they happened. Output of an
+// attempt is printed after its start event and before any later event, since attempts run
+// one by one and their output files are complete once the next event is recorded.
+type logPrinter struct {
+	w         io.Writer
+	run       *report.FileRun
+	opts      *logsOptions
+	streams   []*tailer
+	started   map[int]bool
+	lastLabel string
+}
+
+func (p *logPrinter) print(label string, s string) {
+	if label != p.lastLabel {
+		fmt.Fprintf(p.w, "==> %s <==\n", label)
+		p.lastLabel = label
+	}
+	io.WriteString(p.w, s)
+}
+
+func (p *logPrinter) drainStreams(flush bool) {
+	for _, t := range p.streams {
+		for {
+			line, ok := t.readLine(flush)
+			if !ok {
+				break
+			}
+			p.print(filepath.Base(t.path), line)
+		}
+	}
+}
+
+func (p *logPrinter) closeStreams() {
+	p.drainStreams(true)
+	for _, t := range p.streams {
+		t.close()
+	}
+	p.streams = nil
+}
+
+func (p *logPrinter) openStreams(count int) {
+	p.started[count] = true
+	if p.opts.attempt != 0 && p.opts.attempt != count {
+		return
+	}
+	// the combined log keeps the order of lines across the streams. it is created before the
+	// attempt start is recorded
+	if p.opts.stdout && p.opts.stderr && hasOutputLog(p.run.CombinedLogPath(count)) {
+		p.streams = append(p.streams, &tailer{path: p.run.CombinedLogPath(count)})
+		return
+	}
+	if p.opts.stdout {
+		p.streams = append(p.streams, &tailer{path: p.run.StdoutLogPath(count)})
+	}
+	if p.opts.stderr {
+		p.streams = append(p.streams, &tailer{path: p.run.StderrLogPath(count)})
+	}
+	if p.opts.combined {
+		p.streams = append(p.streams, &tailer{path: p.run.CombinedLogPath(count)})
+	}
+}
+
+func hasOutputLog(path string) bool {
+	fh, err := report.OpenOutputLog(path)
+	if err != nil {
+		return false
+	}
+	fh.Close()
+	return true
+}
+
+func (p *logPrinter) handleEvent(ev report.LifecycleEvent) {
+	// other lines, e.g. truncation notices, can be written while an attempt is running
+	if ev.Event != "" {
+		p.closeStreams()
+	}
+	if p.opts.lifecycle && (p.opts.attempt == 0 || ev.Attempt == 0 || ev.Attempt == p.opts.attempt) {
+		p.print("command.log", ev.Line+"\n")
+	}
+	if ev.IsAttemptStart() {
+		p.openStreams(ev.Attempt)
+	}
+}
+
+func showRun(w io.Writer, run *report.FileRun, opts *logsOptions) error {
+	commandLog := &tailer{path: run.CommandLogPath()}
+	if !commandLog.open() {
+		return fmt.Errorf("cannot open %s", commandLog.path)
+	}
+	defer commandLog.close()
+
+	p := &logPrinter{w: w, run: run, opts: opts, started: make(map[int]bool)}
+	defer p.closeStreams()
+
+	// the label of command.log is only useful when output is interleaved
+	if !opts.stdout && !opts.stderr && !opts.combined {
+		p.lastLabel = "command.log"
+	}
+
+	for {
+		finished := false
+		for {
+			line, ok := commandLog.readLine(!opts.follow)
+			if !ok {
+				break
+			}
+			ev := report.ParseLifecycleLine(strings.TrimRight(line, "\n"))
+			p.handleEvent(ev)
+			if ev.IsCommandEnd() {
+				finished = true
+			}
+		}
+
+		if finished || !opts.follow {
+			break
+		}
+		p.drainStreams(false)
+		time.Sleep(followInterval)
+	}
+
+	if !opts.follow {
+		// attempts without a start event, e.g. the process has failed to start
+		attempts, err := run.Attempts()
+		if err != nil {
+			return err
+		}
+		p.closeStreams()
+		for _, count := range attempts {
+			if !p.started[count] {
+				p.openStreams(count)
+				p.closeStreams()
+			}
+		}
+	}
+	return nil
+}
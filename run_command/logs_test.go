@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/choplin/go-job/report"
+)
+
+func TestShowRun(t *testing.T) {
+	run := &report.FileRun{Directory: t.TempDir(), CommandName: "name", CommandId: "id"}
+	if err := os.MkdirAll(run.Dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "2026-01-02 03:04:05 +0000 UTC [name](id) "
+	lines := []string{
+		prefix + "The command has started",
+		prefix + "The 1st attempt has started. pid: 10",
+		prefix + "The 1st attempt has failed in 1.000000 seconds.: exit status 1.",
+		prefix + "The 2nd attempt has started. pid: 11",
+		prefix + "The 2nd attempt has finished with success in 1.000000 seconds.",
+		prefix + "The command has finished with success in 2.000000 seconds.",
+	}
+	files := map[string]string{
+		run.CommandLogPath(): strings.Join(lines, "\n") + "\n",
+		run.StdoutLogPath(1): "out 1\n",
+		run.StderrLogPath(1): "err 1\n",
+		run.StdoutLogPath(2): "out 2\n",
+		// only the 2nd attempt has the combined log
+		run.CombinedLogPath(2): "2026-01-02T03:04:05.000000Z stderr err 2\n2026-01-02T03:04:05.000001Z stdout out 2\n",
+	}
+	for path, content := range files {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts logsOptions
+		want []string
+	}{
+		{"all", logsOptions{stdout: true, stderr: true, lifecycle: true}, []string{
+			"==> command.log <==", lines[0], lines[1],
+			"==> stdout.log.1 <==", "out 1",
+			"==> stderr.log.1 <==", "err 1",
+			"==> command.log <==", lines[2], lines[3],
+			"==> output.log.2 <==", "2026-01-02T03:04:05.000000Z stderr err 2", "2026-01-02T03:04:05.000001Z stdout out 2",
+			"==> command.log <==", lines[4], lines[5],
+		}},
+		{"combined", logsOptions{combined: true}, []string{
+			"==> output.log.2 <==", "2026-01-02T03:04:05.000000Z stderr err 2", "2026-01-02T03:04:05.000001Z stdout out 2",
+		}},
+		{"follow a finished run", logsOptions{stdout: true, lifecycle: true, follow: true}, []string{
+			"==> command.log <==", lines[0], lines[1],
+			"==> stdout.log.1 <==", "out 1",
+			"==> command.log <==", lines[2], lines[3],
+			"==> stdout.log.2 <==", "out 2",
+			"==> command.log <==", lines[4], lines[5],
+		}},
+		{"lifecycle only", logsOptions{lifecycle: true}, lines},
+		{"attempt", logsOptions{attempt: 2, stdout: true, lifecycle: true}, []string{
+			"==> command.log <==", lines[0], lines[3],
+			"==> stdout.log.2 <==", "out 2",
+			"==> command.log <==", lines[4], lines[5],
+		}},
+		{"output only", logsOptions{stderr: true}, []string{"==> stderr.log.1 <==", "err 1"}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := showRun(&buf, run, &tt.opts); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
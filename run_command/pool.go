@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/choplin/go-job/command"
+	"github.com/choplin/go-job/report"
+)
+
+func runPool(args []string) int {
+	fs := flag.NewFlagSet("pool", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s pool [options] -config commands.yaml\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Run jobs defined in the file with limited concurrency. Jobs with higher priority start first.\n")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "a file of jobs in YAML, or TOML with .toml extension.")
+	concurrency := fs.Int("concurrency", -1, "maximum number of jobs running at the same time. 0 means no limit. a default value is concurrency in the file, or 1.")
+	fs.Parse(args)
+
+	if *config == "" {
+		fmt.Fprintf(os.Stderr, "-config must be specified\n")
+		fs.Usage()
+		return 1
+	}
+	l, err := loadJobList(*config, []string{"concurrency"}, []string{"priority"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load a job list. %s\n", err)
+		return 1
+	}
+
+	n := 1
+	if v, ok := l.values.values["concurrency"]; ok {
+		s, err := l.top.scalar(v, "concurrency")
+		if err == nil {
+			if n, err = strconv.Atoi(s); err != nil || n < 0 {
+				err = l.top.error(v, "concurrency", "invalid value %q. must be a non-negative number", s)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load a job list. %s\n", err)
+			return 1
+		}
+	}
+	if *concurrency >= 0 {
+		n = *concurrency
+	}
+
+	pool := command.NewPool(n)
+	pool.SetFinishHandler(func(r *command.PoolResult, p command.PoolProgress) {
+		result := "succeeded"
+		if !r.Success {
+			result = "failed"
+		}
+		fmt.Fprintf(os.Stderr, "[%d/%d] %s has %s in %f seconds. running: %d, queued: %d\n",
+			p.Succeeded+p.Failed, p.Total(), r.Command.Name(), result, r.EndAt.Sub(r.StartAt).Seconds(), p.Running, p.Queued)
+	})
+
+	// every job is validated before any of them is submitted
+	priorities := make([]int, len(l.entries))
+	for i, e := range l.entries {
+		v, ok := e.values.values["priority"]
+		if !ok {
+			continue
+		}
+		s, err := e.file.scalar(v, "priority")
+		if err == nil {
+			if priorities[i], err = strconv.Atoi(s); err != nil {
+				err = e.file.error(v, "priority", "invalid value %q. must be a number", s)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load a job list. %s\n", err)
+			return 1
+		}
+	}
+
+	cmds := make([]*command.Command, 0, len(l.entries))
+	for _, e := range l.entries {
+		o := e.file.options
+		reporterConfig := &report.ReporterConfig{
+			Labels:    o.labels,
+			Reporters: e.file.reporters,
+			FileOwner: o.fileOwner(),
+		}
+		cmd, err := o.newCommand(e.name(), reporterConfig, e.file.command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to initialize job %s. %s\n", e.name(), err)
+			// reporters of the jobs created so far may hold connections and files
+			for _, c := range cmds {
+				c.Close()
+			}
+			return 1
+		}
+		cmds = append(cmds, cmd)
+	}
+	for i, cmd := range cmds {
+		// never fails before Wait
+		pool.Submit(cmd, priorities[i])
+	}
+
+	pool.Start()
+	pool.Wait()
+	p := pool.Progress()
+	fmt.Fprintf(os.Stderr, "%d succeeded, %d failed\n", p.Succeeded, p.Failed)
+	if p.Failed > 0 {
+		return 1
+	}
+	return 0
+}
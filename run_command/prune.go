@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+func runPrune(args []string) int {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s prune [options] [name...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Remove old runs stored by the file reporter. All commands are pruned if no name is given.\n")
+		fs.PrintDefaults()
+	}
+	directory := fs.String("file-directory", defaultFileDirectory, "a base directory of file reporter.")
+	keepRuns := fs.Int("keep-runs", 0, "number of runs to keep per command. 0 means unlimited.")
+	maxAge := fs.Duration("max-age", time.Duration(0), "remove runs older than this duration. 0 means unlimited.")
+	var maxSize byteSize
+	fs.Var(&maxSize, "max-size", "maximum total size of runs per command, e.g. 500MB. 0 means unlimited.")
+	dryRun := fs.Bool("dry-run", false, "only print runs which would be removed.")
+	fs.Parse(args)
+
+	policy := &report.RetentionPolicy{KeepRuns: *keepRuns, MaxAge: *maxAge, MaxSize: int64(maxSize)}
+	if policy.KeepRuns == 0 && policy.MaxAge == 0 && policy.MaxSize == 0 {
+		fmt.Fprintf(os.Stderr, "at least one of -keep-runs, -max-age and -max-size must be specified\n")
+		return 1
+	}
+
+	names := fs.Args()
+	if len(names) == 0 {
+		var err error
+		if names, err = report.FileCommandNames(*directory); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list commands. %s\n", err)
+			return 1
+		}
+	}
+
+	status := 0
+	now := time.Now()
+	for _, name := range names {
+		var runs []*report.FileRun
+		var err error
+		if *dryRun {
+			runs, err = report.SelectFileRunsToPrune(*directory, name, policy, now)
+		} else {
+			runs, err = report.PruneFileRuns(*directory, name, policy, now)
+		}
+		for _, run := range runs {
+			fmt.Println(run.Dir())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to prune %s. %s\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
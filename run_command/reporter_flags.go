@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/choplin/go-job/report"
+)
+
+// reporterFlags define options of each type of reporter as flags. They are registered with
+// the type as prefix to the command line, e.g. -file-directory, and without prefix to a
+// flag set of each reporter in a job file, e.g. directory.
+var reporterFlags = []struct {
+	typ      string
+	register func(fs *flag.FlagSet, p string) func() report.ReporterOptions
+}{
+	{report.ReporterConsole, registerConsoleFlags},
+	{report.ReporterFluentd, registerFluentdFlags},
+	{report.ReporterFile, registerFileFlags},
+	{report.ReporterLoki, registerLokiFlags},
+	{report.ReporterGelf, registerGelfFlags},
+	{report.ReporterJournald, registerJournaldFlags},
+	{report.ReporterHeartbeat, registerHeartbeatFlags},
+}
+
+type reporterBuilder struct {
+	options func() report.ReporterOptions
+	filter  func() *report.ReporterFilter
+}
+
+// registerReporterFlags registers options and filter flags of the type of reporter.
+func registerReporterFlags(fs *flag.FlagSet, typ string, p string) *reporterBuilder {
+	for _, r := range reporterFlags {
+		if r.typ == typ {
+			return &reporterBuilder{r.register(fs, p), registerFilterFlags(fs, p)}
+		}
+	}
+	return nil
+}
+
+func (b *reporterBuilder) build(name string) (*report.ReporterInstance, error) {
+	inst := &report.ReporterInstance{Name: name, Options: b.options(), Filter: b.filter()}
+	if inst.Filter != nil {
+		if err := inst.Filter.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return inst, nil
+}
+
+// commandLineReporters build reporters from the command line flags.
+var commandLineReporters = make(map[string]*reporterBuilder)
+
+func init() {
+	for _, r := range reporterFlags {
+		commandLineReporters[r.typ] = registerReporterFlags(flag.CommandLine, r.typ, r.typ+"-")
+	}
+}
+
+func registerFilterFlags(fs *flag.FlagSet, p string) func() *report.ReporterFilter {
+	f := &report.ReporterFilter{}
+	fs.Var((*stringList)(&f.Events), p+"events", "events passed to the reporter, separated by ','. available: command_lock, command_start, command_succeed, command_fail, attempt_start, attempt_succeed, attempt_fail, attempt_timeout, attempt_oom, attempt_criteria_fail, attempt_unknown_error, output.")
+	fs.Var((*stringList)(&f.Streams), p+"streams", "output streams passed to the reporter. available: stdout, stderr.")
+	fs.Var((*stringList)(&f.Outcomes), p+"outcomes", "outcomes of attempts and the command passed to the reporter. available: success, failure, timeout, oom, unknown_error. failure includes timeout, oom and unknown_error.")
+	fs.Var((*stringList)(&f.Attempts), p+"attempts", "attempts whose events and output are passed to the reporter, e.g. 1,last. available: numbers, first, last. last is the attempt which has run last, and its events are held until it is known.")
+	return func() *report.ReporterFilter {
+		if len(f.Events) == 0 && len(f.Streams) == 0 && len(f.Outcomes) == 0 && len(f.Attempts) == 0 {
+			return nil
+		}
+		return f
+	}
+}
+
+func registerConsoleFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.ConsoleConfig{}
+	return func() report.ReporterOptions { return c }
+}
+
+func registerFluentdFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.FluentdConfig{}
+	fs.StringVar(&c.Host, p+"host", "localhost", "fluentd host")
+	fs.IntVar(&c.Port, p+"port", 24224, "fluentd port")
+	fs.StringVar(&c.TagPrefix, p+"tag-prefix", "command", "fluentd tag prefix")
+	fs.IntVar(&c.BufferLimit, p+"buffer-limit", 8192, "maximum number of fluentd events buffered in memory while fluentd is unreachable. 0 means unlimited.")
+	fs.StringVar(&c.SpoolDirectory, p+"spool-directory", defaultSpoolDirectory(), "a directory where fluentd events are spooled when the buffer is full or fluentd is unreachable at the end. empty disables spooling. a default value is under $XDG_STATE_HOME or the user cache directory for non-root users.")
+	fs.DurationVar(&c.FlushTimeout, p+"flush-timeout", 5*time.Second, "how long to wait for fluentd to receive remaining events at the end.")
+	fs.StringVar(&c.SocketPath, p+"socket", "", "unix socket path of fluentd. host and port are ignored when specified.")
+	fs.BoolVar(&c.TLS, p+"tls", false, "connect to fluentd with TLS")
+	fs.StringVar(&c.TLSCAFile, p+"tls-ca", "", "CA certificate file to verify fluentd. system roots are used if empty.")
+	fs.StringVar(&c.TLSCertFile, p+"tls-cert", "", "client certificate file for fluentd")
+	fs.StringVar(&c.TLSKeyFile, p+"tls-key", "", "client key file for fluentd")
+	fs.BoolVar(&c.TLSInsecureSkipVerify, p+"tls-insecure-skip-verify", false, "do not verify the certificate of fluentd")
+	fs.StringVar(&c.SharedKey, p+"shared-key", "", "shared key for the forward protocol handshake. the handshake is done only when specified.")
+	fs.StringVar(&c.Username, p+"username", "", "username for fluentd user authentication")
+	fs.StringVar(&c.Password, p+"password", "", "password for fluentd user authentication")
+	fs.BoolVar(&c.RequireAck, p+"require-ack", false, "wait for fluentd to acknowledge each chunk of events, and resend it otherwise")
+	fs.DurationVar(&c.AckTimeout, p+"ack-timeout", 30*time.Second, "how long to wait for an ack from fluentd")
+	fs.StringVar(&c.KeyStyle, p+"key-style", "camel", "naming style of record keys. available: camel, snake.")
+	fs.BoolVar(&c.OmitMessage, p+"omit-message", false, "do not include human readable message in records")
+	return func() report.ReporterOptions { return c }
+}
+
+// defaultSpoolDirectory returns the system spool directory for root, and a directory of the
+// user for others, who cannot write to the system one.
+func defaultSpoolDirectory() string {
+	const systemDir = "/var/spool/go_job/fluentd"
+	if os.Geteuid() == 0 {
+		return systemDir
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "go_job", "fluentd")
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "go_job", "fluentd")
+	}
+	return systemDir
+}
+
+func registerFileFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.FileConfig{}
+	var maxSize, outputLimit byteSize
+	fs.StringVar(&c.Directory, p+"directory", defaultFileDirectory, "a base directory of file reporter. log files will be stored under ${file direcotry}/${command name}/${command id}.")
+	fs.IntVar(&c.Retention.KeepRuns, p+"keep-runs", 0, "number of runs kept by file reporter per command name. 0 means unlimited.")
+	fs.DurationVar(&c.Retention.MaxAge, p+"max-age", time.Duration(0), "runs older than this duration are removed by file reporter. 0 means unlimited.")
+	fs.Var(&maxSize, p+"max-size", "maximum total size of runs kept by file reporter per command name, e.g. 500MB. 0 means unlimited.")
+	fs.StringVar(&c.Compression, p+"compression", "", "compress output files of file reporter when each attempt finishes. available: gzip, zstd.")
+	fs.Var(&outputLimit, p+"output-limit", "file reporter keeps only the first and the last given size of each output stream, e.g. 10MB. 0 means unlimited.")
+	fs.BoolVar(&c.Combined, p+"combined", false, "file reporter also writes output.log.${attempt} where stdout and stderr lines are interleaved with timestamps.")
+	return func() report.ReporterOptions {
+		c.Retention.MaxSize = int64(maxSize)
+		c.OutputLimit = int64(outputLimit)
+		return c
+	}
+}
+
+func registerLokiFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.LokiConfig{}
+	batchSize := byteSize(1 << 20)
+	fs.StringVar(&c.URL, p+"url", "http://localhost:3100/loki/api/v1/push", "URL of the loki push API")
+	fs.StringVar(&c.TenantId, p+"tenant-id", "", "tenant id sent as X-Scope-OrgID header")
+	fs.StringVar(&c.Username, p+"username", "", "username for basic authentication to loki")
+	fs.StringVar(&c.Password, p+"password", "", "password for basic authentication to loki")
+	fs.Var(&batchSize, p+"batch-size", "size of lines which triggers a push to loki, e.g. 1MB")
+	fs.DurationVar(&c.BatchWait, p+"batch-wait", time.Second, "maximum time to wait before pushing a batch to loki")
+	fs.DurationVar(&c.Timeout, p+"timeout", 10*time.Second, "timeout of each push request to loki")
+	fs.IntVar(&c.MaxRetries, p+"max-retries", 5, "maximum number of retries of a push on 429 or 5xx responses")
+	fs.DurationVar(&c.FlushTimeout, p+"flush-timeout", 5*time.Second, "how long to wait for loki to receive remaining lines at the end.")
+	fs.BoolVar(&c.StructuredMetadata, p+"structured-metadata", false, "attach command_id, attempt, scheduled_at and workflow_id to each line as structured metadata. requires loki 2.9 or later.")
+	return func() report.ReporterOptions {
+		c.BatchSize = int(batchSize)
+		return c
+	}
+}
+
+func registerGelfFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.GelfConfig{}
+	fs.StringVar(&c.Protocol, p+"protocol", "udp", "transport of gelf messages. available: udp, tcp.")
+	fs.StringVar(&c.Address, p+"address", "localhost:12201", "address of a gelf input, e.g. graylog:12201")
+	fs.IntVar(&c.ChunkSize, p+"chunk-size", 1420, "maximum size of a udp datagram. larger messages are chunked.")
+	fs.StringVar(&c.Compression, p+"compression", "", "compress udp messages. available: gzip, zlib.")
+	return func() report.ReporterOptions { return c }
+}
+
+func registerJournaldFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.JournaldConfig{}
+	fs.StringVar(&c.SocketPath, p+"socket", "/run/systemd/journal/socket", "native protocol socket of journald")
+	fs.StringVar(&c.Identifier, p+"identifier", "", "SYSLOG_IDENTIFIER of journal entries. a default value is the command name.")
+	return func() report.ReporterOptions { return c }
+}
+
+func registerHeartbeatFlags(fs *flag.FlagSet, p string) func() report.ReporterOptions {
+	c := &report.HeartbeatConfig{}
+	excerptSize := byteSize(10 * 1024)
+	fs.StringVar(&c.StartURL, p+"start-url", "", "URL pinged when the command starts")
+	fs.StringVar(&c.SuccessURL, p+"success-url", "", "URL pinged when the command succeeds")
+	fs.StringVar(&c.FailURL, p+"fail-url", "", "URL pinged when the command fails")
+	fs.DurationVar(&c.Timeout, p+"timeout", 10*time.Second, "timeout of each heartbeat ping")
+	fs.IntVar(&c.Retries, p+"retries", 3, "maximum number of retries of a heartbeat ping")
+	fs.Var(&excerptSize, p+"excerpt-size", "size of the end of output sent with success and failure pings, e.g. 10KB")
+	return func() report.ReporterOptions {
+		c.ExcerptSize = int(excerptSize)
+		return c
+	}
+}
+
+// selectReporters returns reporter instances given by -reporters. Each of them is a name of
+// a reporter defined in the job file, or a type of reporter configured by flags. All the
+// reporters in the job file are used if -reporters is not given.
+func selectReporters(selection string, defined []*report.ReporterInstance, explicit bool) ([]*report.ReporterInstance, error) {
+	if !explicit && len(defined) > 0 {
+		return defined, nil
+	}
+
+	ret := make([]*report.ReporterInstance, 0)
+	for _, s := range strings.Split(selection, ",") {
+		if s == "" {
+			continue
+		}
+		var inst *report.ReporterInstance
+		for _, d := range defined {
+			if d.Name == s {
+				inst = d
+				break
+			}
+		}
+		if inst == nil {
+			b, ok := commandLineReporters[s]
+			if !ok {
+				return nil, fmt.Errorf("unknown reporter: %s", s)
+			}
+			var err error
+			if inst, err = b.build(s); err != nil {
+				return nil, fmt.Errorf("invalid filter of reporter %s. %s", s, err)
+			}
+		}
+		ret = append(ret, inst)
+	}
+	return ret, nil
+}
@@ -5,60 +5,336 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/choplin/go-job/command"
 	"github.com/choplin/go-job/report"
 )
 
+const (
+	defaultFileDirectory = "/var/log/go_job"
+	defaultLockDirectory = "/var/lock/go_job"
+)
+
 var (
-	timeout          = flag.Duration("timeout", time.Duration(0), "timeout duration. See time.ParseDuration on Go document.")
-	attempt          = flag.Int("attempt", 1, "maximum number of attempt")
-	name             = flag.String("name", "", "A name for this command. A default value is a basename of the specified command path.")
-	reporters        = flag.String("reporters", "console", "log reporters. you can specify multiple reporters with ',' as delimiter. available: console, fluentd, file.")
-	fluentdHost      = flag.String("fluentd-host", "localhost", "fluentd host")
-	fluentdPort      = flag.Int("fluentd-port", 24224, "fluentd port")
-	fluentdTagPrefix = flag.String("fluentd-tag-prefix", "command", "fluentd tag prefix")
-	fileDirectory    = flag.String("file-directory", "/var/log/go_job", "a base directory of file reporter. log files will be stored under ${file direcotry}/${command name}/${command id}.")
+	configPath = flag.String("config", "", "a job definition file in YAML, or TOML with .toml extension. flags on the command line override values in the file.")
+	options    = registerJobFlags(flag.CommandLine)
+	reporters  = flag.String("reporters", "console", "log reporters. you can specify multiple reporters with ',' as delimiter. available: console, fluentd, file, loki, gelf, journald, heartbeat, and names of reporters in the job file. options of each type are given by flags prefixed with the type.")
 )
 
+// jobOptions are options of a job given by flags or a job file.
+type jobOptions struct {
+	timeout *time.Duration
+	attempt *int
+	name    *string
+	cwd     *string
+	env     keyValues
+	labels  keyValues
+
+	lock          lockPolicy
+	lockDirectory *string
+	lockTimeout   *time.Duration
+
+	rlimitAS        byteSize
+	rlimitNofile    *uint64
+	rlimitCPU       *time.Duration
+	rlimitCore      optionalByteSize
+	cgroupMemoryMax byteSize
+	cgroupCPUMax    *float64
+	cgroupPidsMax   *int
+	cgroupDirectory *string
+
+	user         *string
+	group        *string
+	groups       stringList
+	resetUserEnv *bool
+	// credential is set by lookupCredential.
+	credential *command.Credential
+
+	shell     *bool
+	shellPath *string
+	template  *bool
+
+	successExitCodes intList
+	stdoutMustMatch  regexpList
+	stdoutMustNot    regexpList
+	stderrMustMatch  regexpList
+	stderrMustNot    regexpList
+	postCheck        *string
+	postCheckTimeout *time.Duration
+}
+
+func registerJobFlags(fs *flag.FlagSet) *jobOptions {
+	o := &jobOptions{
+		timeout: fs.Duration("timeout", time.Duration(0), "timeout duration. See time.ParseDuration on Go document."),
+		attempt: fs.Int("attempt", 1, "maximum number of attempt"),
+		name:    fs.String("name", "", "A name for this command. A default value is a basename of the specified command path."),
+		cwd:     fs.String("cwd", "", "working directory of the command"),
+		env:     keyValues{},
+		labels:  keyValues{},
+
+		lockDirectory: fs.String("lock-directory", defaultLockDirectory, "a directory of lock files, which are named after commands."),
+		lockTimeout:   fs.Duration("lock-timeout", time.Duration(0), "how long to wait for the running instance with the wait policy, or before sending SIGKILL to it with the kill policy. 0 means no limit."),
+
+		rlimitNofile:    fs.Uint64("rlimit-nofile", 0, "maximum number of open files of the process. 0 means unchanged."),
+		rlimitCPU:       fs.Duration("rlimit-cpu", time.Duration(0), "maximum CPU time of the process, rounded up to seconds. 0 means unchanged."),
+		cgroupCPUMax:    fs.Float64("cgroup-cpu-max", 0, "maximum number of CPUs used by each attempt in a cgroup, e.g. 0.5. 0 means unlimited."),
+		cgroupPidsMax:   fs.Int("cgroup-pids-max", 0, "maximum number of processes of each attempt in a cgroup. 0 means unlimited."),
+		cgroupDirectory: fs.String("cgroup-directory", "", "a cgroup v2 directory under which a cgroup is created for each attempt. a default value is /sys/fs/cgroup/go_job."),
+
+		user:         fs.String("user", "", "a user name or uid running the command. directories of the file reporter are owned by the user."),
+		group:        fs.String("group", "", "a group name or gid running the command. a default value is the primary group of the user."),
+		resetUserEnv: fs.Bool("reset-user-env", false, "set HOME, USER and LOGNAME of the command to the ones of the user."),
+
+		shell:            fs.Bool("shell", false, "run the command and arguments joined with spaces as a script of the shell, e.g. -shell 'ls | wc -l'."),
+		shellPath:        fs.String("shell-path", "/bin/sh", "the shell which runs the command with -c in the shell mode."),
+		postCheck:        fs.String("post-check", "", "a script run by the shell after each attempt has exited. the attempt fails unless it exits with 0."),
+		postCheckTimeout: fs.Duration("post-check-timeout", time.Duration(0), "timeout duration of the post-check. 0 means no limit."),
+
+		template: fs.Bool("template", false, "expand templates of Go text/template in the command and arguments for each attempt. available: {{.Id}}, {{.Name}}, {{.Attempt}}, {{.ScheduledTime}}, and date function, e.g. {{.ScheduledTime | date \"2006-01-02\"}}."),
+	}
+	fs.Var(o.env, "env", "an environment variable KEY=VALUE of the command. can be specified multiple times.")
+	fs.Var(o.labels, "label", "a static key=value field added to records. can be specified multiple times.")
+	fs.Var(&o.rlimitAS, "rlimit-as", "maximum size of the address space of the process, e.g. 4GB. 0 means unchanged.")
+	fs.Var(&o.rlimitCore, "rlimit-core", "maximum size of core files of the process, e.g. 0 to disable them.")
+	fs.Var(&o.cgroupMemoryMax, "cgroup-memory-max", "maximum memory of each attempt in a cgroup, e.g. 1GB. an attempt killed by exceeding it is reported as out of memory. 0 means unlimited.")
+	fs.Var(&o.groups, "groups", "supplementary groups of the command by names or gids, separated by ','. a default value is groups of the user.")
+	fs.Var(&o.successExitCodes, "success-exit-codes", "exit codes of a successful attempt, separated by ','. a default value is 0.")
+	fs.Var(&o.stdoutMustMatch, "stdout-must-match", "a regular expression which must match a line of stdout of a successful attempt. can be specified multiple times.")
+	fs.Var(&o.stdoutMustNot, "stdout-must-not-match", "a regular expression which must not match any line of stdout of a successful attempt. can be specified multiple times.")
+	fs.Var(&o.stderrMustMatch, "stderr-must-match", "a regular expression which must match a line of stderr of a successful attempt. can be specified multiple times.")
+	fs.Var(&o.stderrMustNot, "stderr-must-not-match", "a regular expression which must not match any line of stderr of a successful attempt. can be specified multiple times.")
+	fs.Var(&o.lock, "lock", "prevent instances of the command with the same name from running at the same time. the policy when another instance is running. available: skip, wait, kill. skip exits with success.")
+	return o
+}
+
+// lockConfig returns nil if the lock is disabled.
+func (o *jobOptions) lockConfig() *command.LockConfig {
+	if o.lock == "" {
+		return nil
+	}
+	return &command.LockConfig{
+		Directory: *o.lockDirectory,
+		Policy:    string(o.lock),
+		Timeout:   *o.lockTimeout,
+	}
+}
+
+// resourceLimits returns nil if no limit is set.
+func (o *jobOptions) resourceLimits() *command.ResourceLimits {
+	l := &command.ResourceLimits{
+		AddressSpace:    uint64(o.rlimitAS),
+		OpenFiles:       *o.rlimitNofile,
+		CPUTime:         *o.rlimitCPU,
+		MemoryMax:       uint64(o.cgroupMemoryMax),
+		CPUMax:          *o.cgroupCPUMax,
+		PidsMax:         *o.cgroupPidsMax,
+		CgroupDirectory: *o.cgroupDirectory,
+	}
+	if o.rlimitCore.set {
+		size := uint64(o.rlimitCore.size)
+		l.CoreSize = &size
+	}
+	if *l == (command.ResourceLimits{CgroupDirectory: l.CgroupDirectory}) {
+		return nil
+	}
+	return l
+}
+
+// successCriteria returns nil if only the exit code 0 means success.
+func (o *jobOptions) successCriteria() *command.SuccessCriteria {
+	s := &command.SuccessCriteria{
+		ExitCodes:        o.successExitCodes,
+		StdoutMust:       o.stdoutMustMatch,
+		StdoutMustNot:    o.stdoutMustNot,
+		StderrMust:       o.stderrMustMatch,
+		StderrMustNot:    o.stderrMustNot,
+		PostCheckTimeout: *o.postCheckTimeout,
+	}
+	if *o.postCheck != "" {
+		s.PostCheck = []string{*o.shellPath, "-c", *o.postCheck}
+	}
+	if len(s.ExitCodes) == 0 && len(s.StdoutMust) == 0 && len(s.StdoutMustNot) == 0 &&
+		len(s.StderrMust) == 0 && len(s.StderrMustNot) == 0 && s.PostCheck == nil {
+		return nil
+	}
+	return s
+}
+
+// lookupCredential finds the user and groups running the command.
+func (o *jobOptions) lookupCredential() error {
+	if *o.user == "" && *o.group == "" && o.groups == nil {
+		return nil
+	}
+	c, err := command.LookupCredential(*o.user, *o.group, o.groups)
+	if err != nil {
+		return err
+	}
+	c.ResetEnv = *o.resetUserEnv
+	o.credential = c
+	return nil
+}
+
+// fileOwner returns nil if the command runs as the current user.
+func (o *jobOptions) fileOwner() *report.FileOwner {
+	if o.credential == nil {
+		return nil
+	}
+	return &report.FileOwner{Uid: int(o.credential.Uid), Gid: int(o.credential.Gid)}
+}
+
+// argv returns the arguments of the shell in the shell mode.
+func (o *jobOptions) argv(args []string) []string {
+	if !*o.shell {
+		return args
+	}
+	return []string{*o.shellPath, "-c", strings.Join(args, " ")}
+}
+
+// defaultName is a basename of the command, or the first word of the script in the shell mode.
+func (o *jobOptions) defaultName(args []string) string {
+	if *o.shell {
+		if fields := strings.Fields(args[0]); len(fields) > 0 {
+			return path.Base(fields[0])
+		}
+	}
+	return path.Base(args[0])
+}
+
+func (o *jobOptions) validateTemplates(args []string) error {
+	if !*o.template {
+		return nil
+	}
+	for _, s := range o.argv(args) {
+		if err := command.ValidateTemplate(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newCommand creates a command which runs args with the options.
+func (o *jobOptions) newCommand(name string, reporterConfig *report.ReporterConfig, args []string) (*command.Command, error) {
+	argv := o.argv(args)
+	c, err := command.NewCommand(name, o.timeout, *o.attempt, reporterConfig, argv[0], argv[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	if *o.template {
+		if err := c.SetTemplate(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	o.configure(c)
+	return c, nil
+}
+
+// configure applies the options which are not arguments of command.NewCommand.
+func (o *jobOptions) configure(c *command.Command) {
+	c.SetDir(*o.cwd)
+	c.SetEnv(o.env)
+	if lock := o.lockConfig(); lock != nil {
+		c.SetLock(lock)
+	}
+	if limits := o.resourceLimits(); limits != nil {
+		c.SetResourceLimits(limits)
+	}
+	if o.credential != nil {
+		c.SetCredential(o.credential)
+	}
+	if criteria := o.successCriteria(); criteria != nil {
+		c.SetSuccessCriteria(criteria)
+	}
+}
+
 func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s command [args...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] command [args...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -config job.yaml [options] [command [args...]]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s logs [options] name [id]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s prune [options] [name...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s schedule [options] -config jobs.yaml\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s workflow [options] -config workflow.yaml\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s pool [options] -config commands.yaml\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
 func main() {
+	command.RunRlimitWrapper()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "logs":
+			os.Exit(runLogs(os.Args[2:]))
+		case "prune":
+			os.Exit(runPrune(os.Args[2:]))
+		case "schedule":
+			os.Exit(runSchedule(os.Args[2:]))
+		case "workflow":
+			os.Exit(runWorkflow(os.Args[2:]))
+		case "pool":
+			os.Exit(runPool(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 	args := flag.Args()
 
+	var job *jobFile
+	if *configPath != "" {
+		var err error
+		job, err = loadJobFile(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load a job file. %s\n", err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			args = job.command
+		}
+	}
+
 	if len(args) == 0 {
 		fmt.Fprintf(os.Stderr, "command must be specified\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	var defined []*report.ReporterInstance
+	if job != nil {
+		defined = job.reporters
+	}
+	instances, err := selectReporters(*reporters, defined, isFlagSet("reporters"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := options.lookupCredential(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find the user. %s\n", err)
+		os.Exit(1)
+	}
 	reporterConfig := &report.ReporterConfig{
-		Reporters:        *reporters,
-		FluentdHost:      *fluentdHost,
-		FluentdPort:      *fluentdPort,
-		FluentdTagPrefix: *fluentdTagPrefix,
-		FileDirectory:    *fileDirectory,
+		Labels:    options.labels,
+		Reporters: instances,
+		FileOwner: options.fileOwner(),
 	}
 
-	if *name == "" {
-		*name = path.Base(args[0])
+	if *options.name == "" {
+		*options.name = options.defaultName(args)
 	}
 
-	command, err := command.NewCommand(*name, timeout, *attempt, reporterConfig, args[0], args[1:]...)
+	command, err := options.newCommand(*options.name, reporterConfig, args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to initalize command. %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to initialize command. %s\n", err)
 		os.Exit(1)
 	}
 
 	done := command.Start()
+	success := <-done
+	command.Close()
 
-	if success := <-done; success {
+	if success {
 		os.Exit(0)
 	} else {
 		os.Exit(1)
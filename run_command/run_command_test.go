@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestShellMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		args  []string
+		argv  []string
+		cmd   string
+	}{
+		{"command", nil, []string{"/usr/bin/backup", "-v"}, []string{"/usr/bin/backup", "-v"}, "backup"},
+		{"shell", []string{"-shell"}, []string{"/usr/bin/backup -v | gzip", "> out"},
+			[]string{"/bin/sh", "-c", "/usr/bin/backup -v | gzip > out"}, "backup"},
+		{"shell path", []string{"-shell", "-shell-path", "/bin/bash"}, []string{"ls | wc -l"},
+			[]string{"/bin/bash", "-c", "ls | wc -l"}, "ls"},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o := registerJobFlags(fs)
+		if err := fs.Parse(tt.flags); err != nil {
+			t.Fatal(err)
+		}
+		if argv := o.argv(tt.args); !reflect.DeepEqual(argv, tt.argv) {
+			t.Errorf("%s: got argv %v, want %v", tt.name, argv, tt.argv)
+		}
+		if name := o.defaultName(tt.args); name != tt.cmd {
+			t.Errorf("%s: got name %s, want %s", tt.name, name, tt.cmd)
+		}
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags []string
+		args  []string
+		ok    bool
+	}{
+		{"disabled", nil, []string{"echo", "{{.Attempt"}, true},
+		{"valid", []string{"-template"}, []string{"echo", "{{.Attempt}}"}, true},
+		{"invalid", []string{"-template"}, []string{"echo", "{{.Attempt"}, false},
+		{"script", []string{"-template", "-shell"}, []string{"echo {{.Attempt}} | cat"}, true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o := registerJobFlags(fs)
+		if err := fs.Parse(tt.flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := o.validateTemplates(tt.args); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+	}
+}
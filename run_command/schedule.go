@@ -0,0 +1,453 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/choplin/go-job/report"
+	"github.com/robfig/cron/v3"
+)
+
+// Catch-up policies of runs missed while the scheduler was not running.
+const (
+	catchUpSkip = "skip"
+	catchUpOnce = "once"
+	catchUpAll  = "all"
+)
+
+const defaultCatchUpMax = 10
+
+// cronParser accepts expressions with an optional seconds field, and descriptors such as @daily.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// scheduledJob is a job in a schedule file. Each execution creates a new command.Command.
+type scheduledJob struct {
+	options   *jobOptions
+	command   []string
+	reporters []*report.ReporterInstance
+	spec      string
+	schedule  cron.Schedule
+	location  *time.Location
+	// jitter is the maximum random delay of each execution after its fire time.
+	jitter time.Duration
+	// catchUp is applied to missed runs at startup. catchUpMax limits runs with catchUpAll.
+	catchUp    string
+	catchUpMax int
+}
+
+func (j *scheduledJob) name() string {
+	return *j.options.name
+}
+
+func runSchedule(args []string) int {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s schedule [options] -config jobs.yaml\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Run jobs defined in the file on their cron schedules until SIGINT or SIGTERM is received.\n")
+		fmt.Fprintf(os.Stderr, "A fire of a job is skipped while its previous run is still running.\n")
+		fmt.Fprintf(os.Stderr, "Runs missed while the scheduler was not running are caught up at startup by catch_up of each job:\n")
+		fmt.Fprintf(os.Stderr, "skip (default) runs none of them, once runs only the latest one, and all runs the latest catch_up_max (default %d) of them in order.\n", defaultCatchUpMax)
+		fmt.Fprintf(os.Stderr, "Regular fires of a job start after its catch-up runs have finished, and fires during them are skipped.\n")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "a schedule file in YAML, or TOML with .toml extension.")
+	dryRun := fs.Bool("dry-run", false, "only print upcoming fire times of the jobs.")
+	count := fs.Int("count", 10, "number of fire times printed by -dry-run.")
+	statePath := fs.String("state", defaultScheduleStatePath, "a file storing the last fire time of each job to catch up missed runs. use a different file for each schedule file.")
+	fs.Parse(args)
+
+	if *config == "" {
+		fmt.Fprintf(os.Stderr, "-config must be specified\n")
+		fs.Usage()
+		return 1
+	}
+	jobs, err := loadScheduleFile(*config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load a schedule file. %s\n", err)
+		return 1
+	}
+
+	if *dryRun {
+		printFireTimes(jobs, time.Now(), *count)
+		return 0
+	}
+
+	state, err := loadScheduleState(*statePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load the schedule state. %s\n", err)
+		return 1
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	newScheduler(jobs, state).run(stop)
+	return 0
+}
+
+// printFireTimes prints the next count fire times of all the jobs in order.
+func printFireTimes(jobs []*scheduledJob, now time.Time, count int) {
+	next := make([]time.Time, len(jobs))
+	for i, j := range jobs {
+		next[i] = j.schedule.Next(now)
+	}
+	for n := 0; n < count; n++ {
+		i := earliest(next)
+		if i < 0 {
+			return
+		}
+		j := jobs[i]
+		at := next[i].In(j.location).Format(time.RFC3339)
+		if j.jitter > 0 {
+			fmt.Printf("%s\t%s\t(jitter %s)\n", at, j.name(), j.jitter)
+		} else {
+			fmt.Printf("%s\t%s\n", at, j.name())
+		}
+		next[i] = j.schedule.Next(next[i])
+	}
+}
+
+// earliest returns the index of the earliest time, or -1 if all of them are zero, which
+// means that the schedules never fire.
+func earliest(times []time.Time) int {
+	ret := -1
+	for i, t := range times {
+		if t.IsZero() {
+			continue
+		}
+		if ret < 0 || t.Before(times[ret]) {
+			ret = i
+		}
+	}
+	return ret
+}
+
+type scheduler struct {
+	jobs  []*scheduledJob
+	state *scheduleState
+	// stopping is closed when a signal is received so that executions waiting for jitter
+	// are cancelled.
+	stopping chan struct{}
+	running  sync.WaitGroup
+
+	// active are jobs whose run is waiting for jitter or running.
+	activeMu sync.Mutex
+	active   map[*scheduledJob]bool
+}
+
+func newScheduler(jobs []*scheduledJob, state *scheduleState) *scheduler {
+	return &scheduler{jobs: jobs, state: state, stopping: make(chan struct{}), active: make(map[*scheduledJob]bool)}
+}
+
+// activate marks the job as active. It returns false if the job is already active.
+func (s *scheduler) activate(j *scheduledJob) bool {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if s.active[j] {
+		return false
+	}
+	s.active[j] = true
+	return true
+}
+
+func (s *scheduler) deactivate(j *scheduledJob) {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	delete(s.active, j)
+}
+
+func (s *scheduler) run(stop <-chan os.Signal) {
+	now := time.Now()
+	next := make([]time.Time, len(s.jobs))
+	// indexes of jobs whose catch-up runs have finished
+	caughtUp := make(chan int, len(s.jobs))
+	for i, j := range s.jobs {
+		i := i
+		// a job catching up is not scheduled until it has finished
+		if !s.catchUp(j, now, func() { caughtUp <- i }) {
+			next[i] = s.resume(j, now)
+		}
+	}
+
+	for {
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if i := earliest(next); i >= 0 {
+			timer = time.NewTimer(time.Until(next[i]))
+			fire = timer.C
+		}
+
+		select {
+		case i := <-caughtUp:
+			if timer != nil {
+				timer.Stop()
+			}
+			next[i] = s.resume(s.jobs[i], time.Now())
+			continue
+		case sig := <-stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			close(s.stopping)
+			fmt.Fprintf(os.Stderr, "received %s. waiting for running jobs to finish\n", sig)
+			s.running.Wait()
+			return
+		case <-fire:
+		}
+
+		now = time.Now()
+		for i, j := range s.jobs {
+			if next[i].IsZero() || next[i].After(now) {
+				continue
+			}
+			s.state.record(j.name(), next[i])
+			s.execute(j, next[i])
+			next[i] = j.schedule.Next(now)
+		}
+	}
+}
+
+// resume schedules regular fires of the job after now, and returns the next fire time.
+// Fires between the last recorded one and now are skipped.
+func (s *scheduler) resume(j *scheduledJob, now time.Time) time.Time {
+	if last, ok := s.state.lastFireAt(j.name()); ok {
+		if _, skipped := missedFireTimes(j, last, now, 0); skipped > 0 {
+			fmt.Fprintf(os.Stderr, "job %s has skipped %d fires during its catch-up runs\n", j.name(), skipped)
+		}
+	}
+	s.state.record(j.name(), now)
+
+	next := j.schedule.Next(now)
+	if next.IsZero() {
+		fmt.Fprintf(os.Stderr, "job %s never fires\n", j.name())
+	} else {
+		fmt.Fprintf(os.Stderr, "job %s is scheduled at %s\n", j.name(), next.In(j.location).Format(time.RFC3339))
+	}
+	return next
+}
+
+// missedFireTimes returns fire times of the job after last until now, and the number of
+// them. Only the latest max of them are returned, since older runs are less useful.
+func missedFireTimes(j *scheduledJob, last time.Time, now time.Time, max int) ([]time.Time, int) {
+	ret := make([]time.Time, 0, max)
+	missed := 0
+	for t := j.schedule.Next(last); !t.IsZero() && !t.After(now); t = j.schedule.Next(t) {
+		missed++
+		if max == 0 {
+			continue
+		}
+		if len(ret) == max {
+			ret = append(ret[:0], ret[1:]...)
+		}
+		ret = append(ret, t)
+	}
+	return ret, missed
+}
+
+// catchUp runs missed runs of the job in order in background according to its policy, and
+// records now as evaluated. It returns false if there is nothing to catch up, which is
+// always the case for a job seen for the first time. Otherwise done is called after the
+// runs have finished, unless the scheduler is stopping.
+func (s *scheduler) catchUp(j *scheduledJob, now time.Time, done func()) bool {
+	last, ok := s.state.lastFireAt(j.name())
+	s.state.record(j.name(), now)
+	if !ok || j.catchUp == catchUpSkip {
+		return false
+	}
+
+	max := j.catchUpMax
+	if j.catchUp == catchUpOnce {
+		max = 1
+	}
+	times, missed := missedFireTimes(j, last, now, max)
+	if missed == 0 {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "job %s has missed %d runs since %s. catching up the latest %d of them\n", j.name(), missed, last.In(j.location).Format(time.RFC3339), len(times))
+
+	s.activate(j)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer s.deactivate(j)
+		for _, t := range times {
+			select {
+			case <-s.stopping:
+				return
+			default:
+			}
+			s.runJob(j, t, true)
+		}
+		done()
+	}()
+	return true
+}
+
+// execute runs the job in background after a random delay up to its jitter. The fire is
+// skipped if the previous run of the job is still active, so that runs do not overlap.
+func (s *scheduler) execute(j *scheduledJob, scheduledAt time.Time) {
+	if !s.activate(j) {
+		fmt.Fprintf(os.Stderr, "job %s scheduled at %s is skipped since its previous run is still running\n", j.name(), scheduledAt.In(j.location).Format(time.RFC3339))
+		return
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer s.deactivate(j)
+
+		if j.jitter > 0 {
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(j.jitter)))):
+			case <-s.stopping:
+				return
+			}
+		}
+		s.runJob(j, scheduledAt, false)
+	}()
+}
+
+// runJob runs the job scheduled at the time, and waits for it. Catch-up runs are marked
+// with catch_up and scheduled_at labels, and in the message of the command start.
+func (s *scheduler) runJob(j *scheduledJob, scheduledAt time.Time, catchUp bool) {
+	labels := j.options.labels
+	if catchUp {
+		labels = make(map[string]string, len(j.options.labels)+2)
+		for k, v := range j.options.labels {
+			labels[k] = v
+		}
+		labels["catch_up"] = "true"
+		labels["scheduled_at"] = scheduledAt.In(j.location).Format(time.RFC3339)
+	}
+	reporterConfig := &report.ReporterConfig{
+		Labels:    labels,
+		Reporters: j.reporters,
+		FileOwner: j.options.fileOwner(),
+	}
+	if catchUp {
+		reporterConfig.CatchUpOf = scheduledAt.In(j.location)
+	}
+	cmd, err := j.options.newCommand(j.name(), reporterConfig, j.command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize job %s. %s\n", j.name(), err)
+		return
+	}
+	cmd.SetScheduledTime(scheduledAt.In(j.location))
+	<-cmd.Start()
+	cmd.Close()
+}
+
+// loadScheduleFile loads a schedule file, which is a job list with timezone. Each job has
+// schedule, timezone, jitter, catch_up and catch_up_max in addition.
+func loadScheduleFile(file string) ([]*scheduledJob, error) {
+	l, err := loadJobList(file, []string{"timezone"}, []string{"schedule", "timezone", "jitter", "catch_up", "catch_up_max"})
+	if err != nil {
+		return nil, err
+	}
+
+	var timezone string
+	if v, ok := l.values.values["timezone"]; ok {
+		if timezone, err = l.top.scalar(v, "timezone"); err != nil {
+			return nil, err
+		}
+		if err := validateTimezone(l.top, v, "timezone", timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]*scheduledJob, 0, len(l.entries))
+	for _, e := range l.entries {
+		job, err := loadScheduledJob(e, timezone)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, job)
+	}
+	return ret, nil
+}
+
+func loadScheduledJob(e *jobEntry, timezone string) (*scheduledJob, error) {
+	j, m, v := e.file, e.values, e.value
+	job := &scheduledJob{options: j.options, command: j.command, reporters: j.reporters}
+
+	if tv, ok := m.values["timezone"]; ok {
+		var err error
+		if timezone, err = j.scalar(tv, "timezone"); err != nil {
+			return nil, err
+		}
+		if err := validateTimezone(j, tv, "timezone", timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	sv, ok := m.values["schedule"]
+	if !ok {
+		return nil, j.error(v, "schedule", "must be specified")
+	}
+	spec, err := j.scalar(sv, "schedule")
+	if err != nil {
+		return nil, err
+	}
+	job.spec = spec
+	// a timezone in the expression takes precedence, e.g. "CRON_TZ=UTC 0 0 * * *"
+	inline := strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=")
+	if !inline && timezone != "" {
+		spec = "CRON_TZ=" + timezone + " " + spec
+	}
+	if job.schedule, err = cronParser.Parse(spec); err != nil {
+		return nil, j.error(sv, "schedule", "invalid value %q. %s", job.spec, err)
+	}
+	if inline {
+		timezone = strings.TrimPrefix(strings.TrimPrefix(strings.Fields(spec)[0], "CRON_TZ="), "TZ=")
+	}
+	job.location = time.Local
+	if timezone != "" {
+		if job.location, err = time.LoadLocation(timezone); err != nil {
+			return nil, j.error(sv, "schedule", "unknown timezone %q", timezone)
+		}
+	}
+
+	if jv, ok := m.values["jitter"]; ok {
+		s, err := j.scalar(jv, "jitter")
+		if err != nil {
+			return nil, err
+		}
+		if job.jitter, err = time.ParseDuration(s); err != nil || job.jitter < 0 {
+			return nil, j.error(jv, "jitter", "invalid value %q. must be a non-negative duration", s)
+		}
+	}
+
+	job.catchUp = catchUpSkip
+	if cv, ok := m.values["catch_up"]; ok {
+		if job.catchUp, err = j.scalar(cv, "catch_up"); err != nil {
+			return nil, err
+		}
+		if job.catchUp != catchUpSkip && job.catchUp != catchUpOnce && job.catchUp != catchUpAll {
+			return nil, j.error(cv, "catch_up", "invalid value %q. available: skip, once, all", job.catchUp)
+		}
+	}
+	job.catchUpMax = defaultCatchUpMax
+	if cv, ok := m.values["catch_up_max"]; ok {
+		s, err := j.scalar(cv, "catch_up_max")
+		if err != nil {
+			return nil, err
+		}
+		if job.catchUpMax, err = strconv.Atoi(s); err != nil || job.catchUpMax < 1 {
+			return nil, j.error(cv, "catch_up_max", "invalid value %q. must be a positive number", s)
+		}
+	}
+	return job, nil
+}
+
+func validateTimezone(j *jobFile, v *configValue, key string, name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return j.error(v, key, "unknown timezone %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultScheduleStatePath = "/var/lib/go_job/schedule.json"
+
+// scheduleState persists the last fire time of each job, so that runs missed while the
+// scheduler was not running are found at startup.
+type scheduleState struct {
+	path string
+	mu   sync.Mutex
+	// LastFireAt is the latest fire time which has been run or evaluated for catch-up.
+	LastFireAt map[string]time.Time `json:"lastFireAt"`
+}
+
+func loadScheduleState(path string) (*scheduleState, error) {
+	s := &scheduleState{path: path, LastFireAt: map[string]time.Time{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if s.LastFireAt == nil {
+		s.LastFireAt = map[string]time.Time{}
+	}
+	return s, nil
+}
+
+func (s *scheduleState) lastFireAt(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.LastFireAt[name]
+	return t, ok
+}
+
+// record saves the fire time of the job. A failure is only printed, since the scheduler
+// keeps working without the state.
+func (s *scheduleState) record(name string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastFireAt[name] = t
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	}
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, append(b, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save the schedule state. %s\n", err)
+	}
+}
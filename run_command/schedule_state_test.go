@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "schedule.json")
+	s, err := loadScheduleState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.lastFireAt("backup"); ok {
+		t.Errorf("a job has a fire time in a new state")
+	}
+
+	at := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	s.record("backup", at)
+	s.record("report", at.Add(time.Hour))
+
+	loaded, err := loadScheduleState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]time.Time{"backup": at, "report": at.Add(time.Hour)} {
+		if got, ok := loaded.lastFireAt(name); !ok || !got.Equal(want) {
+			t.Errorf("%s: got %s, %v, want %s", name, got, ok, want)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadScheduleState(path); err == nil {
+		t.Errorf("no error for a broken state")
+	}
+}
+
+func TestSchedulerCatchUpNothing(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 1, 2, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		catchUp string
+		last    time.Time
+	}{
+		{"first seen", catchUpAll, time.Time{}},
+		{"skip", catchUpSkip, at(1)},
+		{"no missed fire", catchUpAll, at(3).Add(30 * time.Minute)},
+	}
+	for _, tt := range tests {
+		state, err := loadScheduleState(filepath.Join(t.TempDir(), "schedule.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule, _ := cronParser.Parse("0 0 * * * *")
+		name := "backup"
+		j := &scheduledJob{
+			options:    &jobOptions{name: &name},
+			spec:       "0 0 * * * *",
+			schedule:   schedule,
+			location:   time.UTC,
+			catchUp:    tt.catchUp,
+			catchUpMax: defaultCatchUpMax,
+		}
+		if !tt.last.IsZero() {
+			state.record(j.name(), tt.last)
+		}
+
+		now := at(3).Add(45 * time.Minute)
+		s := newScheduler([]*scheduledJob{j}, state)
+		if s.catchUp(j, now, func() {}) {
+			t.Errorf("%s: caught up", tt.name)
+		}
+		// now is recorded as evaluated, so that the runs are not caught up again
+		if last, _ := state.lastFireAt(j.name()); !last.Equal(now) {
+			t.Errorf("%s: got last fire time %s, want %s", tt.name, last, now)
+		}
+	}
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 1, 2, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name    string
+		catchUp string
+		max     int
+		want    int
+	}{
+		{"once", catchUpOnce, defaultCatchUpMax, 1},
+		{"all", catchUpAll, defaultCatchUpMax, 3},
+		{"all up to max", catchUpAll, 2, 2},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		state, err := loadScheduleState(filepath.Join(dir, "schedule.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(dir, "out")
+		options := registerJobFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+		*options.name = "backup"
+		schedule, _ := cronParser.Parse("0 0 * * * *")
+		j := &scheduledJob{
+			options:    options,
+			command:    []string{"/bin/sh", "-c", "echo run >> " + out},
+			spec:       "0 0 * * * *",
+			schedule:   schedule,
+			location:   time.UTC,
+			catchUp:    tt.catchUp,
+			catchUpMax: tt.max,
+		}
+		state.record(j.name(), at(0))
+
+		s := newScheduler([]*scheduledJob{j}, state)
+		done := make(chan struct{})
+		if !s.catchUp(j, at(3).Add(45*time.Minute), func() { close(done) }) {
+			t.Errorf("%s: nothing caught up", tt.name)
+			continue
+		}
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: catch-up runs have not finished", tt.name)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs := strings.Count(string(b), "run\n"); runs != tt.want {
+			t.Errorf("%s: got %d runs, want %d", tt.name, runs, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissedFireTimes(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2026, 1, 2, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		spec   string
+		last   time.Time
+		now    time.Time
+		max    int
+		times  []time.Time
+		missed int
+	}{
+		{"none", "0 0 * * * *", at(3), at(3).Add(30 * time.Minute), 5, []time.Time{}, 0},
+		{"now is a fire time", "0 0 * * * *", at(3), at(4), 5, []time.Time{at(4)}, 1},
+		{"all within max", "0 0 * * * *", at(3), at(6), 5, []time.Time{at(4), at(5), at(6)}, 3},
+		{"latest max", "0 0 * * * *", at(3), at(9), 2, []time.Time{at(8), at(9)}, 6},
+		{"count only", "0 0 * * * *", at(3), at(9), 0, []time.Time{}, 6},
+		{"every", "@every 2h", at(3), at(9), 5, []time.Time{at(5), at(7), at(9)}, 3},
+	}
+	for _, tt := range tests {
+		schedule, err := cronParser.Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j := &scheduledJob{spec: tt.spec, schedule: schedule, location: time.UTC}
+		times, missed := missedFireTimes(j, tt.last, tt.now, tt.max)
+		if !reflect.DeepEqual(times, tt.times) || missed != tt.missed {
+			t.Errorf("%s: got %v, %d, want %v, %d", tt.name, times, missed, tt.times, tt.missed)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/choplin/go-job/command"
+	"github.com/choplin/go-job/report"
+)
+
+// workflowDef is a workflow file, which is a job list with name and parallelism. Each job
+// has depends_on in addition.
+type workflowDef struct {
+	name        string
+	parallelism int
+	labels      keyValues
+	reporters   []*report.ReporterInstance
+	steps       []*command.WorkflowStep
+}
+
+func runWorkflow(args []string) int {
+	fs := flag.NewFlagSet("workflow", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s workflow [options] -config workflow.yaml\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Run jobs defined in the file in order of their dependencies. Dependents of failed jobs are skipped.\n")
+		fs.PrintDefaults()
+	}
+	config := fs.String("config", "", "a workflow file in YAML, or TOML with .toml extension.")
+	parallelism := fs.Int("parallelism", -1, "maximum number of jobs running at the same time. 0 means no limit. a default value is parallelism in the file, or 1.")
+	fs.Parse(args)
+
+	if *config == "" {
+		fmt.Fprintf(os.Stderr, "-config must be specified\n")
+		fs.Usage()
+		return 1
+	}
+	def, err := loadWorkflowFile(*config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load a workflow file. %s\n", err)
+		return 1
+	}
+	if *parallelism >= 0 {
+		def.parallelism = *parallelism
+	}
+
+	reporterConfig := &report.ReporterConfig{
+		Labels:    def.labels,
+		Reporters: def.reporters,
+	}
+	workflow, err := command.NewWorkflow(def.name, def.steps, def.parallelism, reporterConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to initialize workflow. %s\n", err)
+		return 1
+	}
+	success := <-workflow.Start()
+	workflow.Close()
+
+	if success {
+		return 0
+	}
+	return 1
+}
+
+func loadWorkflowFile(file string) (*workflowDef, error) {
+	l, err := loadJobList(file, []string{"name", "parallelism"}, []string{"depends_on"})
+	if err != nil {
+		return nil, err
+	}
+
+	def := &workflowDef{
+		name:        strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		parallelism: 1,
+		labels:      l.labels,
+		reporters:   l.reporters,
+	}
+	if v, ok := l.values.values["name"]; ok {
+		if def.name, err = l.top.scalar(v, "name"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := l.values.values["parallelism"]; ok {
+		s, err := l.top.scalar(v, "parallelism")
+		if err != nil {
+			return nil, err
+		}
+		if def.parallelism, err = strconv.Atoi(s); err != nil || def.parallelism < 0 {
+			return nil, l.top.error(v, "parallelism", "invalid value %q. must be a non-negative number", s)
+		}
+	}
+	if def.reporters == nil {
+		def.reporters = []*report.ReporterInstance{{Name: report.ReporterConsole, Options: &report.ConsoleConfig{}}}
+	}
+
+	names := make(map[string]bool)
+	for _, e := range l.entries {
+		names[e.name()] = true
+	}
+	for _, e := range l.entries {
+		step := &command.WorkflowStep{Name: e.name(), Command: workflowStepCommand(def.name, e)}
+		if v, ok := e.values.values["depends_on"]; ok {
+			s, err := e.file.scalarOrList(v, "depends_on")
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range strings.Split(s, ",") {
+				if d = strings.TrimSpace(d); d == "" {
+					continue
+				}
+				if !names[d] {
+					return nil, e.file.error(v, "depends_on", "unknown job %s", d)
+				}
+				step.DependsOn = append(step.DependsOn, d)
+			}
+		}
+		def.steps = append(def.steps, step)
+	}
+
+	if err := command.ValidateWorkflow(def.steps); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return def, nil
+}
+
+// workflowStepCommand returns a function which creates a command of the job. Records of the
+// job have workflow and workflow_id labels.
+func workflowStepCommand(workflow string, e *jobEntry) func(string) (*command.Command, error) {
+	o := e.file.options
+	return func(workflowId string) (*command.Command, error) {
+		labels := make(map[string]string, len(o.labels)+2)
+		for k, v := range o.labels {
+			labels[k] = v
+		}
+		labels["workflow"] = workflow
+		labels["workflow_id"] = workflowId
+		reporterConfig := &report.ReporterConfig{
+			Labels:    labels,
+			Reporters: e.file.reporters,
+			FileOwner: o.fileOwner(),
+		}
+		return o.newCommand(e.name(), reporterConfig, e.file.command)
+	}
+}